@@ -3,6 +3,7 @@ package balancer
 import (
 	"errors"
 	"net"
+	"time"
 )
 
 type BalancerTyp string
@@ -12,16 +13,47 @@ const (
 	P2cType             BalancerTyp = "p2c"
 	ConsistencyHashType BalancerTyp = "consistency_hash"
 	RangeType           BalancerTyp = "range"
+	RoundRobinType      BalancerTyp = "round_robin"
+	MaglevType          BalancerTyp = "maglev"
+)
+
+const (
+	// times to pick again when the picked host of a balancer out of this package is not available.
+	PICK_AVAILABLE_RETRY = 3
 )
 
 type balancerItem struct {
 	addr  string
 	wight int
+
+	// key range [rangeStart, rangeEnd) owned by the addr, valid for `range`.
+	// empty rangeEnd means no upper bound.
+	rangeStart string
+	rangeEnd   string
+
+	// locality label of the addr, eg: availability zone, valid for the zone aware balancer.
+	zone string
 }
 
 type balancerConfig struct {
 	balancerTyp     BalancerTyp
 	balancerConfigs []balancerItem
+
+	// label of the metrics.
+	serviceName string
+
+	// ε of the consistent hashing with bounded loads, valid for `consistency_hash`.
+	// 0 means no bound.
+	boundedLoadFactor float64
+
+	// slow start window of the hosts added by Add, valid for `random`, `p2c` and `round_robin`.
+	// 0 means no slow start.
+	slowStartMs int64
+	// shape of the slow start curve, 1 means linear.
+	slowStartAggression float64
+
+	// conn pool of every host, GetHost returns nil conn when it is nil.
+	connPool *ConnPoolConfig
 }
 
 type HostInfo interface {
@@ -29,15 +61,34 @@ type HostInfo interface {
 	GetHost() (net.Conn, error)
 }
 
+// DoneInfo is the outcome of a request sent to the picked host.
+type DoneInfo struct {
+	Err     error
+	Latency time.Duration
+
+	// the picked host is given up without sending the request,
+	// only the pick is released and nothing is learned.
+	Skipped bool
+}
+
+// DoneFunc reports the outcome of a request back to the balancer,
+// so that load-aware strategies can learn from it.
+// It must be called exactly once for every successful Pick.
+type DoneFunc func(info DoneInfo)
+
 type Balancer interface {
 	// Pick well get a HostInfo interface.
 	// The HostInfo contains link information.
-	// param `key` valid for `consistency_hash`
-	Pick(key []byte) (HostInfo, error)
+	// param `key` valid for `consistency_hash`, `maglev` and `range`
+	// The caller completes the request through the returned DoneFunc.
+	Pick(key []byte) (HostInfo, DoneFunc, error)
 
 	Add(conf ...balancerItem) error
 
 	Remove(addr string) error
+
+	// Hosts returns every host of the balancer, including the ones that are not pickable.
+	Hosts() []HostInfo
 }
 
 /*
@@ -51,6 +102,28 @@ func NewBalancerItem(addr string, wight int) balancerItem {
 	}
 }
 
+// the addr is in the zone, eg: availability zone, the zone must not be empty.
+func NewZoneBalancerItem(addr string, wight int, zone string) balancerItem {
+	return balancerItem{
+		addr:  addr,
+		wight: wight,
+		zone:  zone,
+	}
+}
+
+/*
+the addr owns the keys in [start, end), compared byte by byte.
+empty start means no lower bound, empty end means no upper bound.
+*/
+func NewRangeBalancerItem(addr string, wight int, start, end string) balancerItem {
+	return balancerItem{
+		addr:       addr,
+		wight:      wight,
+		rangeStart: start,
+		rangeEnd:   end,
+	}
+}
+
 func NewBalancerConfig() *balancerConfig {
 	return &balancerConfig{}
 }
@@ -63,17 +136,111 @@ func (b *balancerConfig) SetBalancerTyp(typ BalancerTyp) {
 	b.balancerTyp = typ
 }
 
+func (b *balancerConfig) SetServiceName(serviceName string) {
+	b.serviceName = serviceName
+}
+
+/*
+a host takes at most (1+ε) times the average inflight, the rest walk to the next host on the ring.
+The smaller ε, the more balanced but the less sticky. eg: 0.25.
+*/
+func (b *balancerConfig) SetBoundedLoadFactor(epsilon float64) {
+	b.boundedLoadFactor = epsilon
+}
+
+/*
+SetSlowStart ramps the wight of a host added by Add up to its full wight in windowMs.
+The effective wight is wight * max(SLOW_START_MIN_WIGHT, (t / windowMs) ^ (1 / aggression)),
+aggression 1 ramps linearly, a larger aggression ramps faster at the beginning.
+*/
+func (b *balancerConfig) SetSlowStart(windowMs int64, aggression float64) {
+	b.slowStartMs = windowMs
+	b.slowStartAggression = aggression
+}
+
+// SetConnPool makes GetHost borrow the conns from the pool of every host.
+func (b *balancerConfig) SetConnPool(conf *ConnPoolConfig) {
+	b.connPool = conf
+}
+
 func NewBalancer(conf balancerConfig) (Balancer, error) {
 	switch conf.balancerTyp {
 	case RandomType:
 		return NewRandomBalancer(conf)
 	case P2cType:
-		return nil, nil
+		return NewP2cBalancer(conf)
 	case ConsistencyHashType:
 		return NewConsistencyHashBalancer(conf)
+	case MaglevType:
+		return NewMaglevBalancer(conf)
 	case RangeType:
-		return nil, nil
+		return NewRangeBalancer(conf)
+	case RoundRobinType:
+		return NewRoundRobinBalancer(conf)
 	default:
 		return nil, errors.New("illegal type")
 	}
 }
+
+var errNoAvailableHost = errors.New("no available host")
+
+/*
+availablePicker is implemented by the balancers that can pick among the available hosts only.
+pickFrom returns errNoAvailableHost when none of the hosts is available.
+*/
+type availablePicker interface {
+	pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error)
+}
+
+// allAvailable accepts a host when all the checkers accept it.
+func allAvailable(checkers ...func(addr string) bool) func(addr string) bool {
+	return func(addr string) bool {
+		for _, available := range checkers {
+			if available != nil && !available(addr) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+/*
+pickFrom picks among the available hosts of b, or returns errNoAvailableHost.
+A balancer out of this package is picked again with salted keys at most PICK_AVAILABLE_RETRY times,
+so that the key based balancers can move on to other hosts.
+*/
+func pickFrom(b Balancer, key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	if p, ok := b.(availablePicker); ok {
+		return p.pickFrom(key, available)
+	}
+
+	pickKey := key
+	for i := 0; i <= PICK_AVAILABLE_RETRY; i++ {
+		if i > 0 {
+			pickKey = append(append([]byte{}, key...), byte(i))
+		}
+
+		host, done, err := b.Pick(pickKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if available(host.GetAddr()) {
+			return host, done, nil
+		}
+		done(DoneInfo{Skipped: true})
+	}
+	return nil, nil, errNoAvailableHost
+}
+
+/*
+pickAvailable picks among the available hosts of b, the unavailable hosts are never picked
+unless all the hosts are unavailable, then it falls back to b.Pick rather than failing the request.
+*/
+func pickAvailable(b Balancer, key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	host, done, err := pickFrom(b, key, available)
+	if err == errNoAvailableHost {
+		return b.Pick(key)
+	}
+	return host, done, err
+}
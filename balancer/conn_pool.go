@@ -0,0 +1,249 @@
+package balancer
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EAHITechnology/raptor/utils"
+)
+
+const (
+	// how long a borrowed idle conn is read to check that the peer has not closed it.
+	CONN_CHECK_TIMEOUT = 100 * time.Microsecond
+)
+
+var (
+	ErrConnPoolExhausted = errors.New("conn pool exhausted")
+	ErrConnPoolClosed    = errors.New("conn pool closed")
+	ErrPoolConnClosed    = errors.New("pool conn already closed")
+)
+
+type ConnPoolConfig struct {
+	maxActive     int // max conns of a host, including the idle ones
+	maxIdle       int // max idle conns of a host
+	idleTimeoutMs int64
+	dialTimeoutMs int64
+}
+
+/*
+the default config keeps at most 2 idle conns for 90s, the conns are not limited.
+*/
+func NewConnPoolConfig() *ConnPoolConfig {
+	return &ConnPoolConfig{
+		maxIdle:       2,
+		idleTimeoutMs: 90000,
+		dialTimeoutMs: 1000,
+	}
+}
+
+// 0 means no limit.
+func (c *ConnPoolConfig) SetMaxActive(maxActive int) *ConnPoolConfig {
+	c.maxActive = maxActive
+	return c
+}
+
+func (c *ConnPoolConfig) SetMaxIdle(maxIdle int) *ConnPoolConfig {
+	c.maxIdle = maxIdle
+	return c
+}
+
+// 0 means the idle conns never expire.
+func (c *ConnPoolConfig) SetIdleTimeoutMs(idleTimeoutMs int64) *ConnPoolConfig {
+	c.idleTimeoutMs = idleTimeoutMs
+	return c
+}
+
+// 0 means no dial timeout.
+func (c *ConnPoolConfig) SetDialTimeoutMs(dialTimeoutMs int64) *ConnPoolConfig {
+	c.dialTimeoutMs = dialTimeoutMs
+	return c
+}
+
+type idleConn struct {
+	conn        net.Conn
+	idleSinceMs int64
+}
+
+/*
+connPool is the tcp conn pool of a single host.
+The conns are dialed on demand, the idle ones are reused from the most recent.
+An idle conn is closed when it is borrowed or a conn is returned after it expires,
+or when the peer has closed it.
+*/
+type connPool struct {
+	addr string
+	conf ConnPoolConfig
+
+	lock   sync.Mutex
+	idle   []idleConn
+	open   int // idle and borrowed conns
+	closed bool
+}
+
+/*
+PoolConn is the conn borrowed from the pool by HostInfo.GetHost.
+Close returns it to the pool, Discard closes it when it is broken.
+*/
+type PoolConn struct {
+	net.Conn
+	pool *connPool
+	once sync.Once
+}
+
+func newConnPool(addr string, conf *ConnPoolConfig) *connPool {
+	return &connPool{
+		addr: addr,
+		conf: *conf,
+	}
+}
+
+// is the conn still usable, the peer may have closed it while it was idle.
+func connAlive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(CONN_CHECK_TIMEOUT)); err != nil {
+		return false
+	}
+
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return false
+	}
+
+	// nothing to read, unexpected data or eof means the conn is broken.
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// pop the most recent idle conn, guarded by lock.
+func (c *connPool) popIdle() (idleConn, bool) {
+	n := len(c.idle)
+	if n == 0 {
+		return idleConn{}, false
+	}
+
+	ic := c.idle[n-1]
+	c.idle = c.idle[:n-1]
+	return ic, true
+}
+
+/*
+close the expired idle conns, guarded by lock.
+The idle conns are kept from the oldest, so the expired ones are at the front.
+*/
+func (c *connPool) reapIdle(nowMs int64) {
+	if c.conf.idleTimeoutMs <= 0 {
+		return
+	}
+
+	n := 0
+	for n < len(c.idle) && nowMs-c.idle[n].idleSinceMs >= c.conf.idleTimeoutMs {
+		c.idle[n].conn.Close()
+		c.open--
+		n++
+	}
+	if n > 0 {
+		c.idle = append(c.idle[:0], c.idle[n:]...)
+	}
+}
+
+func (c *connPool) get() (net.Conn, error) {
+	for {
+		c.lock.Lock()
+		if c.closed {
+			c.lock.Unlock()
+			return nil, ErrConnPoolClosed
+		}
+
+		ic, ok := c.popIdle()
+		if !ok {
+			// keep the lock to dial a new conn.
+			break
+		}
+		c.lock.Unlock()
+
+		// check the conn out of the lock, it takes CONN_CHECK_TIMEOUT.
+		expired := c.conf.idleTimeoutMs > 0 && utils.GetNowMs()-ic.idleSinceMs >= c.conf.idleTimeoutMs
+		if !expired && connAlive(ic.conn) {
+			return &PoolConn{Conn: ic.conn, pool: c}, nil
+		}
+		c.discard(ic.conn)
+	}
+
+	if c.conf.maxActive > 0 && c.open >= c.conf.maxActive {
+		c.lock.Unlock()
+		return nil, ErrConnPoolExhausted
+	}
+	c.open++
+	c.lock.Unlock()
+
+	conn, err := net.DialTimeout("tcp", c.addr, time.Duration(c.conf.dialTimeoutMs)*time.Millisecond)
+	if err != nil {
+		c.lock.Lock()
+		c.open--
+		c.lock.Unlock()
+		return nil, err
+	}
+	return &PoolConn{Conn: conn, pool: c}, nil
+}
+
+func (c *connPool) put(conn net.Conn) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		c.open--
+		return conn.Close()
+	}
+
+	// the pool is not scanned in the background, the expired idle conns are closed here.
+	nowMs := utils.GetNowMs()
+	c.reapIdle(nowMs)
+	if len(c.idle) >= c.conf.maxIdle {
+		c.open--
+		return conn.Close()
+	}
+
+	c.idle = append(c.idle, idleConn{conn: conn, idleSinceMs: nowMs})
+	return nil
+}
+
+func (c *connPool) discard(conn net.Conn) error {
+	c.lock.Lock()
+	c.open--
+	c.lock.Unlock()
+
+	return conn.Close()
+}
+
+// close the idle conns, the borrowed ones are closed when they are returned.
+func (c *connPool) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.closed = true
+	for _, ic := range c.idle {
+		ic.conn.Close()
+		c.open--
+	}
+	c.idle = nil
+}
+
+// Close returns the conn to the pool.
+func (p *PoolConn) Close() error {
+	err := ErrPoolConnClosed
+	p.once.Do(func() {
+		err = p.pool.put(p.Conn)
+	})
+	return err
+}
+
+// Discard closes the broken conn instead of returning it to the pool.
+func (p *PoolConn) Discard() error {
+	err := ErrPoolConnClosed
+	p.once.Do(func() {
+		err = p.pool.discard(p.Conn)
+	})
+	return err
+}
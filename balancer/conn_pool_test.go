@@ -0,0 +1,196 @@
+package balancer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listen on a random port, the accepted conns are sent to the chan.
+func newTestListener(t *testing.T) (net.Listener, chan net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return ln, accepted
+}
+
+func TestConnPool_Reuse(t *testing.T) {
+	ln, accepted := newTestListener(t)
+	defer ln.Close()
+
+	pool := newConnPool(ln.Addr().String(), NewConnPoolConfig().SetMaxIdle(1))
+
+	conn, err := pool.get()
+	assert.Nil(t, err)
+	local := conn.LocalAddr().String()
+	<-accepted
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, conn.Close(), ErrPoolConnClosed)
+
+	// the idle conn is reused.
+	conn, err = pool.get()
+	assert.Nil(t, err)
+	assert.Equal(t, conn.LocalAddr().String(), local)
+
+	// only 1 idle conn is kept.
+	another, err := pool.get()
+	assert.Nil(t, err)
+	<-accepted
+	assert.Nil(t, conn.Close())
+	assert.Nil(t, another.Close())
+	assert.Equal(t, len(pool.idle), 1)
+	assert.Equal(t, pool.open, 1)
+
+	pool.close()
+	assert.Equal(t, pool.open, 0)
+	_, err = pool.get()
+	assert.Equal(t, err, ErrConnPoolClosed)
+}
+
+func TestConnPool_Validate(t *testing.T) {
+	ln, accepted := newTestListener(t)
+	defer ln.Close()
+
+	pool := newConnPool(ln.Addr().String(), NewConnPoolConfig().SetIdleTimeoutMs(100))
+
+	conn, err := pool.get()
+	assert.Nil(t, err)
+	local := conn.LocalAddr().String()
+	peer := <-accepted
+	assert.Nil(t, conn.Close())
+
+	// the peer closes the idle conn, a new conn is dialed.
+	peer.Close()
+	time.Sleep(10 * time.Millisecond)
+	conn, err = pool.get()
+	assert.Nil(t, err)
+	assert.NotEqual(t, conn.LocalAddr().String(), local)
+	local = conn.LocalAddr().String()
+	<-accepted
+	assert.Nil(t, conn.Close())
+
+	// the idle conn expires.
+	time.Sleep(150 * time.Millisecond)
+	conn, err = pool.get()
+	assert.Nil(t, err)
+	assert.NotEqual(t, conn.LocalAddr().String(), local)
+	assert.Equal(t, pool.open, 1)
+}
+
+func TestConnPool_ReapIdle(t *testing.T) {
+	ln, _ := newTestListener(t)
+	defer ln.Close()
+
+	pool := newConnPool(ln.Addr().String(), NewConnPoolConfig().SetIdleTimeoutMs(100))
+
+	a, err := pool.get()
+	assert.Nil(t, err)
+	b, err := pool.get()
+	assert.Nil(t, err)
+	assert.Nil(t, a.Close())
+
+	// the expired idle conn is closed when another conn is returned.
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, b.Close())
+	assert.Equal(t, len(pool.idle), 1)
+	assert.Equal(t, pool.open, 1)
+	assert.Equal(t, pool.idle[0].conn, b.(*PoolConn).Conn)
+}
+
+func TestConnPool_MaxActive(t *testing.T) {
+	ln, _ := newTestListener(t)
+	defer ln.Close()
+
+	pool := newConnPool(ln.Addr().String(), NewConnPoolConfig().SetMaxActive(2))
+
+	a, err := pool.get()
+	assert.Nil(t, err)
+	_, err = pool.get()
+	assert.Nil(t, err)
+
+	_, err = pool.get()
+	assert.Equal(t, err, ErrConnPoolExhausted)
+
+	// the discarded conn is not returned to the pool.
+	assert.Nil(t, a.(*PoolConn).Discard())
+	assert.Equal(t, len(pool.idle), 0)
+	_, err = pool.get()
+	assert.Nil(t, err)
+}
+
+func TestBalancer_GetHost(t *testing.T) {
+	ln, _ := newTestListener(t)
+	defer ln.Close()
+
+	conf := balancerConfig{
+		balancerTyp:     RoundRobinType,
+		balancerConfigs: []balancerItem{NewBalancerItem(ln.Addr().String(), 1)},
+	}
+
+	// no conn pool.
+	b, err := NewBalancer(conf)
+	assert.Nil(t, err)
+	host, done, err := b.Pick(nil)
+	assert.Nil(t, err)
+	conn, err := host.GetHost()
+	assert.Nil(t, err)
+	assert.Nil(t, conn)
+	done(DoneInfo{})
+
+	conf.SetConnPool(NewConnPoolConfig())
+	for _, typ := range []BalancerTyp{RandomType, P2cType, ConsistencyHashType, MaglevType, RoundRobinType} {
+		conf.balancerTyp = typ
+		b, err := NewBalancer(conf)
+		assert.Nil(t, err)
+
+		host, done, err := b.Pick([]byte("key"))
+		assert.Nil(t, err)
+		conn, err := host.GetHost()
+		assert.Nil(t, err)
+		assert.Nil(t, conn.Close())
+		done(DoneInfo{})
+
+		// the idle conns are closed with the host.
+		assert.Nil(t, b.Remove(ln.Addr().String()))
+		_, err = host.GetHost()
+		assert.Equal(t, err, ErrConnPoolClosed)
+	}
+}
+
+func TestRangeBalancer_CarvedHostPool(t *testing.T) {
+	ln, _ := newTestListener(t)
+	defer ln.Close()
+
+	conf := balancerConfig{
+		balancerTyp:     RangeType,
+		balancerConfigs: []balancerItem{NewRangeBalancerItem(ln.Addr().String(), 1, "a", "m")},
+	}
+	conf.SetConnPool(NewConnPoolConfig())
+	b, err := NewRangeBalancer(conf)
+	assert.Nil(t, err)
+
+	host, done, err := b.Pick([]byte("b"))
+	assert.Nil(t, err)
+	conn, err := host.GetHost()
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Close())
+	done(DoneInfo{})
+
+	// the whole range is taken over by another host.
+	assert.Nil(t, b.Add(NewRangeBalancerItem("xx.xxx.xxx.02", 1, "a", "m")))
+	assert.Equal(t, len(b.Hosts()), 1)
+	_, err = host.GetHost()
+	assert.Equal(t, err, ErrConnPoolClosed)
+}
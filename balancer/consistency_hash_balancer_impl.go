@@ -2,74 +2,117 @@ package balancer
 
 import (
 	"errors"
+	"math"
 	"net"
 	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/EAHITechnology/raptor/utils"
 )
 
 const (
+	// max hosts of a ring, and max virtual nodes of a host.
 	MAX_HSAHNODE_NUM = 1024
 
+	// virtual nodes of every unit of wight.
+	VIRTUAL_NODE_NUM = 40
+
 	CONSISTENCY_HASH_WAIT_SORT = -1
 )
 
 type consistencyHashHostInfo struct {
-	addr      string
-	wight     int
-	hashValue uint64
+	addr  string
+	wight int
 
-	//todo
-	inflight      int
-	clientSuccess int
-	requestCount  int
-	latency       float64
+	*hostStat
 }
 
 func (c *consistencyHashHostInfo) GetAddr() string {
 	return c.addr
 }
 
+func (c *consistencyHashHostInfo) getWight() int {
+	return c.wight
+}
+
 func (c *consistencyHashHostInfo) GetHost() (net.Conn, error) {
-	return nil, nil
+	return c.getConn()
+}
+
+// consistencyHashNode is a virtual node on the ring.
+type consistencyHashNode struct {
+	hashValue uint64
+	host      *consistencyHashHostInfo
 }
 
-type consistencyHashHostInfoList []consistencyHashHostInfo
+type consistencyHashNodeList []consistencyHashNode
 
-func (c consistencyHashHostInfoList) Len() int {
+func (c consistencyHashNodeList) Len() int {
 	return len(c)
 }
 
-func (c consistencyHashHostInfoList) Less(i, j int) bool {
+func (c consistencyHashNodeList) Less(i, j int) bool {
 	return c[i].hashValue < c[j].hashValue
 }
 
-func (c consistencyHashHostInfoList) Swap(i, j int) {
+func (c consistencyHashNodeList) Swap(i, j int) {
 	c[i], c[j] = c[j], c[i]
 }
 
+/*
+consistencyHashBalancer places `wight * VIRTUAL_NODE_NUM` virtual nodes of every host on the ring.
+
+With bounded loads (boundedLoadFactor ε > 0), a host accepts at most
+ceil((1+ε) * (inflight+1) / hosts) requests in flight, where inflight is the total of all hosts,
+and hosts counts only the hosts on the ring.
+When the host of a key is full, Pick walks clockwise to the next host that is not,
+so the keys stay sticky until a host is overloaded.
+*/
 type consistencyHashBalancer struct {
 	conf     balancerConfig
-	hashRing []consistencyHashHostInfo
+	hashRing []consistencyHashNode
+	hosts    []*consistencyHashHostInfo
 	lock     sync.RWMutex
 	addrMap  map[string]int
+	// hosts owning virtual nodes, the hosts with wight 0 are not on the ring.
+	ringHosts int
+
+	// total inflight of all hosts, read/write through atomic operation
+	inflight int64
 }
 
-func getHashRing(items []balancerItem) ([]consistencyHashHostInfo, map[string]int) {
-	ring, addrMap := []consistencyHashHostInfo{}, make(map[string]int)
+func getVirtualNodeNum(wight int) int {
+	num := wight * VIRTUAL_NODE_NUM
+	if num > MAX_HSAHNODE_NUM {
+		num = MAX_HSAHNODE_NUM
+	}
+	return num
+}
+
+func getHashRing(items []balancerItem, stats map[string]*hostStat, poolConf *ConnPoolConfig) ([]consistencyHashNode, []*consistencyHashHostInfo, map[string]int) {
+	ring, hosts, addrMap := []consistencyHashNode{}, []*consistencyHashHostInfo{}, make(map[string]int)
 
 	for idx, item := range items {
-		hostinfo := consistencyHashHostInfo{
-			addr:      item.addr,
-			wight:     item.wight,
-			hashValue: utils.MurmurHash64A([]byte(item.addr)),
+		hostinfo := &consistencyHashHostInfo{
+			addr:     item.addr,
+			wight:    item.wight,
+			hostStat: getHostStat(stats, item.addr, poolConf),
+		}
+
+		for i := 0; i < getVirtualNodeNum(item.wight); i++ {
+			ring = append(ring, consistencyHashNode{
+				hashValue: utils.MurmurHash64A([]byte(item.addr + "#" + strconv.Itoa(i))),
+				host:      hostinfo,
+			})
 		}
-		ring = append(ring, hostinfo)
+		hosts = append(hosts, hostinfo)
 		addrMap[item.addr] = idx
 	}
-	sort.Sort(consistencyHashHostInfoList(ring))
-	return ring, addrMap
+	sort.Sort(consistencyHashNodeList(ring))
+	return ring, hosts, addrMap
 }
 
 func NewConsistencyHashBalancer(conf balancerConfig) (*consistencyHashBalancer, error) {
@@ -81,25 +124,59 @@ func NewConsistencyHashBalancer(conf balancerConfig) (*consistencyHashBalancer,
 		return nil, errors.New("addr nil")
 	}
 
-	ring, addrMap := getHashRing(conf.balancerConfigs)
+	if conf.boundedLoadFactor < 0 {
+		return nil, errors.New("bounded load factor illegal")
+	}
+
+	ring, hosts, addrMap := getHashRing(conf.balancerConfigs, nil, conf.connPool)
 
 	return &consistencyHashBalancer{
-		conf:     conf,
-		hashRing: ring,
-		addrMap:  addrMap,
+		conf:      conf,
+		hashRing:  ring,
+		hosts:     hosts,
+		addrMap:   addrMap,
+		ringHosts: getRingHosts(hosts),
 	}, nil
 }
 
-func (c *consistencyHashBalancer) Pick(key []byte) (HostInfo, error) {
+func (c *consistencyHashBalancer) stats() map[string]*hostStat {
+	stats := make(map[string]*hostStat)
+	for _, host := range c.hosts {
+		stats[host.addr] = host.hostStat
+	}
+	return stats
+}
+
+// the max inflight of a host under bounded loads.
+func (c *consistencyHashBalancer) loadLimit() int64 {
+	inflight := atomic.LoadInt64(&c.inflight)
+	return int64(math.Ceil((1 + c.conf.boundedLoadFactor) * float64(inflight+1) / float64(c.ringHosts)))
+}
+
+func getRingHosts(hosts []*consistencyHashHostInfo) int {
+	num := 0
+	for _, host := range hosts {
+		if getVirtualNodeNum(host.wight) > 0 {
+			num++
+		}
+	}
+	return num
+}
+
+func (c *consistencyHashBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return c.pickFrom(key, nil)
+}
+
+/*
+nil available means all the hosts are available.
+The keys of an unavailable host walk clockwise to the next available host, like the full hosts.
+*/
+func (c *consistencyHashBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
 	if len(c.hashRing) == 0 {
-		return nil, errors.New("list is null")
-	}
-
-	if len(c.hashRing) == 1 {
-		return &c.hashRing[0], nil
+		return nil, nil, errors.New("list is null")
 	}
 
 	hashval := utils.MurmurHash64A(key)
@@ -107,8 +184,62 @@ func (c *consistencyHashBalancer) Pick(key []byte) (HostInfo, error) {
 	idx := sort.Search(len(c.hashRing), func(i int) bool {
 		return c.hashRing[i].hashValue >= hashval
 	})
+	// the ring wraps around.
+	if idx == len(c.hashRing) {
+		idx = 0
+	}
+
+	limit := int64(0)
+	if c.conf.boundedLoadFactor > 0 {
+		limit = c.loadLimit()
+	}
+
+	// every host is checked once, a host owns many virtual nodes.
+	var checked map[*consistencyHashHostInfo]bool
+	if available != nil {
+		checked = make(map[*consistencyHashHostInfo]bool)
+	}
+	isAvailable := func(host *consistencyHashHostInfo) bool {
+		if available == nil {
+			return true
+		}
+		ok, seen := checked[host]
+		if !seen {
+			ok = available(host.addr)
+			checked[host] = ok
+		}
+		return ok
+	}
+
+	// the first available host, taken when all the available hosts are full.
+	var host, first *consistencyHashHostInfo
+	for i := 0; i < len(c.hashRing); i++ {
+		candidate := c.hashRing[(idx+i)%len(c.hashRing)].host
+		if !isAvailable(candidate) {
+			continue
+		}
+		if first == nil {
+			first = candidate
+		}
+		if limit == 0 || candidate.getInflight() < limit {
+			host = candidate
+			break
+		}
+	}
 
-	return &c.hashRing[idx], nil
+	if first == nil {
+		return nil, nil, errNoAvailableHost
+	}
+	if host == nil {
+		host = first
+	}
+
+	atomic.AddInt64(&c.inflight, 1)
+	host.acquire(time.Now().UnixNano())
+	return host, func(info DoneInfo) {
+		atomic.AddInt64(&c.inflight, -1)
+		host.done(info)
+	}, nil
 }
 
 func (c *consistencyHashBalancer) Add(conf ...balancerItem) error {
@@ -124,7 +255,8 @@ func (c *consistencyHashBalancer) Add(conf ...balancerItem) error {
 		c.addrMap[val.addr] = CONSISTENCY_HASH_WAIT_SORT
 	}
 
-	c.hashRing, c.addrMap = getHashRing(c.conf.balancerConfigs)
+	c.hashRing, c.hosts, c.addrMap = getHashRing(c.conf.balancerConfigs, c.stats(), c.conf.connPool)
+	c.ringHosts = getRingHosts(c.hosts)
 	return nil
 }
 
@@ -146,6 +278,20 @@ func (c *consistencyHashBalancer) Remove(addr string) error {
 	c.conf.balancerConfigs = append(c.conf.balancerConfigs, tmpFront...)
 	c.conf.balancerConfigs = append(c.conf.balancerConfigs, tmpBackend...)
 
-	c.hashRing, c.addrMap = getHashRing(c.conf.balancerConfigs)
+	stats := c.stats()
+	c.hashRing, c.hosts, c.addrMap = getHashRing(c.conf.balancerConfigs, stats, c.conf.connPool)
+	c.ringHosts = getRingHosts(c.hosts)
+	closeHostStat(stats, addr)
 	return nil
 }
+
+func (c *consistencyHashBalancer) Hosts() []HostInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	hosts := []HostInfo{}
+	for _, host := range c.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
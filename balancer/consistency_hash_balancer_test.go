@@ -2,6 +2,7 @@ package balancer
 
 import (
 	"fmt"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -38,8 +39,117 @@ func TestNewConsistencyHashBalancer(t *testing.T) {
 	}
 	balancer, err := NewConsistencyHashBalancer(conf)
 	assert.Nil(t, err)
-	host, err := balancer.Pick([]byte("23472"))
+	host, done, err := balancer.Pick([]byte("23472"))
 	assert.Nil(t, err)
+	done(DoneInfo{})
 
 	fmt.Println("addr:", host.GetAddr())
 }
+
+func TestConsistencyHashBalancer_VirtualNode(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: ConsistencyHashType,
+		balancerConfigs: []balancerItem{
+			NewBalancerItem("xx.xxx.xxx.01", 1),
+			NewBalancerItem("xx.xxx.xxx.02", 1),
+			NewBalancerItem("xx.xxx.xxx.03", 1),
+			NewBalancerItem("xx.xxx.xxx.04", 3),
+		},
+	}
+	balancer, err := NewConsistencyHashBalancer(conf)
+	assert.Nil(t, err)
+	assert.Equal(t, len(balancer.hashRing), 6*VIRTUAL_NODE_NUM)
+
+	count := map[string]int{}
+	for i := 0; i < 60000; i++ {
+		host, done, err := balancer.Pick([]byte(fmt.Sprintf("key_%d", i)))
+		assert.Nil(t, err)
+		done(DoneInfo{})
+		count[host.GetAddr()]++
+	}
+
+	// every unit of wight takes about 10000 keys.
+	for _, addr := range []string{"xx.xxx.xxx.01", "xx.xxx.xxx.02", "xx.xxx.xxx.03"} {
+		assert.True(t, count[addr] > 6000 && count[addr] < 14000, addr)
+	}
+	assert.True(t, count["xx.xxx.xxx.04"] > 22000 && count["xx.xxx.xxx.04"] < 38000)
+
+	// the same key always goes to the same host.
+	host, done, err := balancer.Pick([]byte("key_1"))
+	assert.Nil(t, err)
+	done(DoneInfo{})
+	for i := 0; i < 10; i++ {
+		again, done, err := balancer.Pick([]byte("key_1"))
+		assert.Nil(t, err)
+		done(DoneInfo{})
+		assert.Equal(t, again.GetAddr(), host.GetAddr())
+	}
+}
+
+func TestConsistencyHashBalancer_BoundedLoad(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: ConsistencyHashType,
+		balancerConfigs: []balancerItem{
+			NewBalancerItem("xx.xxx.xxx.01", 1),
+			NewBalancerItem("xx.xxx.xxx.02", 1),
+			NewBalancerItem("xx.xxx.xxx.03", 1),
+			NewBalancerItem("xx.xxx.xxx.04", 1),
+		},
+		boundedLoadFactor: 0.25,
+	}
+	balancer, err := NewConsistencyHashBalancer(conf)
+	assert.Nil(t, err)
+
+	// a hot key, nothing finishes.
+	count := map[string]int{}
+	dones := []DoneFunc{}
+	for i := 0; i < 100; i++ {
+		host, done, err := balancer.Pick([]byte("hot_key"))
+		assert.Nil(t, err)
+		count[host.GetAddr()]++
+		dones = append(dones, done)
+	}
+
+	// ceil(1.25 * 100 / 4)
+	assert.Equal(t, len(count), 4)
+	for addr, num := range count {
+		assert.True(t, num <= 32, addr)
+	}
+
+	for _, done := range dones {
+		done(DoneInfo{})
+	}
+	assert.Equal(t, balancer.inflight, int64(0))
+
+	// the hot key is sticky again once the load is gone.
+	first, done, err := balancer.Pick([]byte("hot_key"))
+	assert.Nil(t, err)
+	done(DoneInfo{})
+	again, done, err := balancer.Pick([]byte("hot_key"))
+	assert.Nil(t, err)
+	done(DoneInfo{})
+	assert.Equal(t, first.GetAddr(), again.GetAddr())
+}
+
+func TestConsistencyHashBalancer_BoundedLoadZeroWight(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: ConsistencyHashType,
+		balancerConfigs: []balancerItem{
+			NewBalancerItem("xx.xxx.xxx.01", 1),
+			NewBalancerItem("xx.xxx.xxx.02", 1),
+			NewBalancerItem("xx.xxx.xxx.03", 1),
+			NewBalancerItem("xx.xxx.xxx.04", 1),
+			NewBalancerItem("xx.xxx.xxx.05", 0),
+		},
+		boundedLoadFactor: 0.25,
+	}
+	balancer, err := NewConsistencyHashBalancer(conf)
+	assert.Nil(t, err)
+
+	// the host with wight 0 is not on the ring, ceil(1.25 * 100 / 4).
+	atomic.StoreInt64(&balancer.inflight, 99)
+	assert.Equal(t, balancer.loadLimit(), int64(32))
+
+	assert.Nil(t, balancer.Remove("xx.xxx.xxx.04"))
+	assert.Equal(t, balancer.loadLimit(), int64(42))
+}
@@ -0,0 +1,308 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/EAHITechnology/raptor/utils"
+)
+
+type HealthCheckTyp string
+
+const (
+	HttpHealthCheck HealthCheckTyp = "http"
+	TcpHealthCheck  HealthCheckTyp = "tcp"
+)
+
+type HealthCheckConfig struct {
+	// label of the metrics.
+	serviceName string
+	typ         HealthCheckTyp
+	// scheme and path of the http probe, eg: http, /health.
+	scheme             string
+	path               string
+	intervalMs         int64
+	timeoutMs          int64
+	healthyThreshold   int64 // successful probes in a row
+	unhealthyThreshold int64 // failed probes in a row
+}
+
+// healthCheckHost is the probe state of a host.
+type healthCheckHost struct {
+	healthy   bool
+	successes int64
+	failures  int64
+}
+
+/*
+healthCheckBalancer wraps a Balancer and probes every host of it every `intervalMs`.
+
+A host is marked unhealthy after `unhealthyThreshold` failed probes in a row,
+and marked healthy again after `healthyThreshold` successful probes in a row.
+The hosts are healthy until they are probed.
+Pick only picks among the healthy hosts, and falls back to the unhealthy ones
+only when all the hosts are unhealthy.
+*/
+type healthCheckBalancer struct {
+	b      Balancer
+	conf   HealthCheckConfig
+	client *http.Client
+	lock   sync.RWMutex
+	hosts  map[string]*healthCheckHost
+}
+
+/*
+the default config probes with a tcp connect every 5s with 1s timeout,
+a host is unhealthy after 3 failed probes and healthy after 2 successful probes.
+*/
+func NewHealthCheckConfig() *HealthCheckConfig {
+	return &HealthCheckConfig{
+		typ:                TcpHealthCheck,
+		scheme:             "http",
+		path:               "/",
+		intervalMs:         5000,
+		timeoutMs:          1000,
+		healthyThreshold:   2,
+		unhealthyThreshold: 3,
+	}
+}
+
+func (c *HealthCheckConfig) SetServiceName(serviceName string) *HealthCheckConfig {
+	c.serviceName = serviceName
+	return c
+}
+
+func (c *HealthCheckConfig) SetTyp(typ HealthCheckTyp) *HealthCheckConfig {
+	c.typ = typ
+	return c
+}
+
+func (c *HealthCheckConfig) SetScheme(scheme string) *HealthCheckConfig {
+	c.scheme = scheme
+	return c
+}
+
+func (c *HealthCheckConfig) SetPath(path string) *HealthCheckConfig {
+	c.path = path
+	return c
+}
+
+func (c *HealthCheckConfig) SetIntervalMs(intervalMs int64) *HealthCheckConfig {
+	c.intervalMs = intervalMs
+	return c
+}
+
+func (c *HealthCheckConfig) SetTimeoutMs(timeoutMs int64) *HealthCheckConfig {
+	c.timeoutMs = timeoutMs
+	return c
+}
+
+func (c *HealthCheckConfig) SetHealthyThreshold(healthyThreshold int64) *HealthCheckConfig {
+	c.healthyThreshold = healthyThreshold
+	return c
+}
+
+func (c *HealthCheckConfig) SetUnhealthyThreshold(unhealthyThreshold int64) *HealthCheckConfig {
+	c.unhealthyThreshold = unhealthyThreshold
+	return c
+}
+
+/*
+NewHealthCheckBalancer starts probing the hosts of b,
+the probing stops when ctx is done.
+*/
+func NewHealthCheckBalancer(ctx context.Context, b Balancer, conf *HealthCheckConfig) (*healthCheckBalancer, error) {
+	if b == nil || utils.IsNil(b) {
+		return nil, errors.New("balancer nil")
+	}
+
+	if conf.typ != HttpHealthCheck && conf.typ != TcpHealthCheck {
+		return nil, errors.New("health check typ illegal")
+	}
+
+	if conf.intervalMs <= 0 || conf.timeoutMs <= 0 || conf.healthyThreshold <= 0 || conf.unhealthyThreshold <= 0 {
+		return nil, errors.New("health check config illegal")
+	}
+
+	h := &healthCheckBalancer{
+		b:    b,
+		conf: *conf,
+		client: &http.Client{
+			Timeout: time.Duration(conf.timeoutMs) * time.Millisecond,
+		},
+		hosts: make(map[string]*healthCheckHost),
+	}
+
+	go h.run(ctx)
+	return h, nil
+}
+
+func (h *healthCheckBalancer) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.conf.intervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		h.checkAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe all the hosts concurrently and wait for the results.
+func (h *healthCheckBalancer) checkAll(ctx context.Context) {
+	hosts := h.b.Hosts()
+
+	wg := sync.WaitGroup{}
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			h.report(addr, h.probe(ctx, addr))
+		}(host.GetAddr())
+	}
+	wg.Wait()
+
+	// forget the hosts removed from the balancer.
+	exists := make(map[string]bool)
+	for _, host := range hosts {
+		exists[host.GetAddr()] = true
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for addr := range h.hosts {
+		if !exists[addr] {
+			delete(h.hosts, addr)
+			deleteHostEjected(h.conf.serviceName, addr, ejectedByHealthCheck)
+		}
+	}
+}
+
+func (h *healthCheckBalancer) probe(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(h.conf.timeoutMs)*time.Millisecond)
+	defer cancel()
+
+	if h.conf.typ == TcpHealthCheck {
+		return probeTcp(ctx, addr, h.conf.scheme)
+	}
+	return h.probeHttp(ctx, addr)
+}
+
+// the addr may have no port, eg: www.baidu.com, the default port of the scheme is used.
+func probeTcp(ctx context.Context, addr, scheme string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		port := "80"
+		if scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(addr, port)
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// a 2xx or 3xx response means healthy.
+func (h *healthCheckBalancer) probeHttp(ctx context.Context, addr string) error {
+	url, err := utils.Write(h.conf.scheme, "://", addr, h.conf.path)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 400 {
+		return fmt.Errorf("health check status : %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (h *healthCheckBalancer) report(addr string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	host, ok := h.hosts[addr]
+	if !ok {
+		host = &healthCheckHost{healthy: true}
+		h.hosts[addr] = host
+	}
+
+	if err == nil {
+		host.failures = 0
+		host.successes++
+		if !host.healthy && host.successes >= h.conf.healthyThreshold {
+			host.healthy = true
+			setHostEjected(h.conf.serviceName, addr, ejectedByHealthCheck, false)
+		}
+		return
+	}
+
+	host.successes = 0
+	host.failures++
+	if host.healthy && host.failures >= h.conf.unhealthyThreshold {
+		host.healthy = false
+		setHostEjected(h.conf.serviceName, addr, ejectedByHealthCheck, true)
+	}
+}
+
+// IsAvailable reports whether the host is healthy.
+func (h *healthCheckBalancer) IsAvailable(addr string) bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	host, ok := h.hosts[addr]
+	if !ok {
+		return true
+	}
+	return host.healthy
+}
+
+func (h *healthCheckBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return pickAvailable(h.b, key, h.IsAvailable)
+}
+
+func (h *healthCheckBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	return pickFrom(h.b, key, allAvailable(available, h.IsAvailable))
+}
+
+func (h *healthCheckBalancer) Add(conf ...balancerItem) error {
+	return h.b.Add(conf...)
+}
+
+func (h *healthCheckBalancer) Remove(addr string) error {
+	if err := h.b.Remove(addr); err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.hosts, addr)
+	deleteHostEjected(h.conf.serviceName, addr, ejectedByHealthCheck)
+	return nil
+}
+
+func (h *healthCheckBalancer) Hosts() []HostInfo {
+	return h.b.Hosts()
+}
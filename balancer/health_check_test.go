@@ -0,0 +1,155 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckBalancer_Http(t *testing.T) {
+	// the server is healthy while status is 200.
+	status := int64(http.StatusOK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/health")
+		w.WriteHeader(int(atomic.LoadInt64(&status)))
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	// always healthy.
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	healthyAddr := strings.TrimPrefix(healthy.URL, "http://")
+
+	b, err := NewRoundRobinBalancer(balancerConfig{
+		balancerTyp:     RoundRobinType,
+		balancerConfigs: []balancerItem{NewBalancerItem(addr, 1), NewBalancerItem(healthyAddr, 1)},
+	})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := NewHealthCheckBalancer(ctx, b, NewHealthCheckConfig().
+		SetTyp(HttpHealthCheck).
+		SetPath("/health").
+		SetIntervalMs(20).
+		SetTimeoutMs(100).
+		SetHealthyThreshold(2).
+		SetUnhealthyThreshold(2))
+	assert.Nil(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, h.IsAvailable(addr))
+
+	atomic.StoreInt64(&status, http.StatusInternalServerError)
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, h.IsAvailable(addr))
+
+	// the unhealthy host is skipped.
+	for i := 0; i < 10; i++ {
+		host, done, err := h.Pick(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, host.GetAddr(), healthyAddr)
+		done(DoneInfo{})
+	}
+
+	atomic.StoreInt64(&status, http.StatusOK)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, h.IsAvailable(addr))
+}
+
+func TestHealthCheckBalancer_Tcp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	// nothing listens on the addr of a closed listener.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	b, err := NewRandomBalancer(balancerConfig{
+		balancerTyp:     RandomType,
+		balancerConfigs: []balancerItem{NewBalancerItem(ln.Addr().String(), 1), NewBalancerItem(closedAddr, 1)},
+	})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := NewHealthCheckBalancer(ctx, b, NewHealthCheckConfig().
+		SetIntervalMs(20).
+		SetTimeoutMs(100).
+		SetUnhealthyThreshold(1))
+	assert.Nil(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, h.IsAvailable(ln.Addr().String()))
+	assert.False(t, h.IsAvailable(closedAddr))
+
+	assert.Nil(t, h.Remove(closedAddr))
+	assert.True(t, h.IsAvailable(closedAddr))
+	assert.Equal(t, len(h.Hosts()), 1)
+}
+
+func TestHealthCheckBalancer_ConfigIllegal(t *testing.T) {
+	b, err := NewRandomBalancer(balancerConfig{
+		balancerTyp:     RandomType,
+		balancerConfigs: []balancerItem{NewBalancerItem("a", 1)},
+	})
+	assert.Nil(t, err)
+
+	_, err = NewHealthCheckBalancer(context.Background(), b, NewHealthCheckConfig().SetTyp("udp"))
+	assert.NotNil(t, err)
+
+	_, err = NewHealthCheckBalancer(context.Background(), b, NewHealthCheckConfig().SetIntervalMs(0))
+	assert.NotNil(t, err)
+}
+
+func TestHealthCheckBalancer_MostUnhealthy(t *testing.T) {
+	list := []balancerItem{}
+	for i := 0; i < 10; i++ {
+		list = append(list, NewBalancerItem(fmt.Sprintf("xx.xxx.xxx.%02d", i), 1))
+	}
+
+	for _, typ := range []BalancerTyp{RandomType, P2cType, RoundRobinType, ConsistencyHashType, MaglevType} {
+		b, err := NewBalancer(balancerConfig{balancerTyp: typ, balancerConfigs: list})
+		assert.Nil(t, err)
+
+		// not probing, the results are reported by the test.
+		h := &healthCheckBalancer{b: b, conf: *NewHealthCheckConfig().SetUnhealthyThreshold(1), hosts: make(map[string]*healthCheckHost)}
+		for i := 1; i < 10; i++ {
+			h.report(fmt.Sprintf("xx.xxx.xxx.%02d", i), errors.New("just_error"))
+		}
+
+		// 9 of the 10 hosts are unhealthy, only the healthy one is picked.
+		for i := 0; i < 1000; i++ {
+			host, done, err := h.Pick([]byte(fmt.Sprintf("key_%d", i)))
+			assert.Nil(t, err)
+			assert.Equal(t, host.GetAddr(), "xx.xxx.xxx.00", typ)
+			done(DoneInfo{})
+		}
+
+		// all the hosts are unhealthy, fall back to them.
+		h.report("xx.xxx.xxx.00", errors.New("just_error"))
+		count := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			host, done, err := h.Pick([]byte(fmt.Sprintf("key_%d", i)))
+			assert.Nil(t, err)
+			count[host.GetAddr()]++
+			done(DoneInfo{})
+		}
+		assert.Equal(t, len(count), 10, typ)
+	}
+}
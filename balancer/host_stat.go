@@ -0,0 +1,159 @@
+package balancer
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// decay time constant of the ewma statistics.
+	EWMA_DECAY_NS = int64(600 * time.Millisecond)
+
+	// a host that has not been picked for this long is picked once
+	// to refresh its statistics.
+	FORCE_PICK_NS = int64(time.Second)
+
+	// lower bound of the success ratio, avoid dividing by zero.
+	MIN_SUCCESS_RATIO = 0.01
+)
+
+/*
+hostStat is the load statistics and the conn pool of a single host.
+It is shared by all the balancers so that load-aware strategies
+can compare hosts by the same measurement.
+*/
+type hostStat struct {
+	lock sync.Mutex
+
+	// picked but not finished requests
+	inflight int64
+	// finished requests
+	requestCount int64
+	// ewma of the success ratio, in [0, 1]
+	clientSuccess float64
+	// ewma of the latency, in nanoseconds
+	latency float64
+
+	lastPickNs int64
+	lastDoneNs int64
+
+	// when the host was added by Add, 0 for the hosts the balancer was created with.
+	// It is set before the host is visible to Pick and never changes.
+	addedNs int64
+
+	// nil when the balancer has no conn pool config.
+	pool *connPool
+}
+
+func newHostStat() *hostStat {
+	return &hostStat{
+		clientSuccess: 1,
+	}
+}
+
+/*
+reuse the statistics of hosts that survive a rebuild.
+stats is nil when the balancer is created, otherwise a missing host is added by Add.
+*/
+func getHostStat(stats map[string]*hostStat, addr string, poolConf *ConnPoolConfig) *hostStat {
+	if stat, ok := stats[addr]; ok {
+		return stat
+	}
+
+	stat := newHostStat()
+	if stats != nil {
+		stat.addedNs = time.Now().UnixNano()
+	}
+	if poolConf != nil {
+		stat.pool = newConnPool(addr, poolConf)
+	}
+	return stat
+}
+
+// close the conn pool of the host removed from the balancer.
+func closeHostStat(stats map[string]*hostStat, addr string) {
+	if stat, ok := stats[addr]; ok && stat.pool != nil {
+		stat.pool.close()
+	}
+}
+
+// getConn is the GetHost of the hosts, nil when there is no conn pool.
+func (h *hostStat) getConn() (net.Conn, error) {
+	if h.pool == nil {
+		return nil, nil
+	}
+	return h.pool.get()
+}
+
+func (h *hostStat) acquire(nowNs int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.inflight++
+	h.lastPickNs = nowNs
+}
+
+// done is the DoneFunc returned by Pick.
+func (h *hostStat) done(info DoneInfo) {
+	nowNs := time.Now().UnixNano()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.inflight > 0 {
+		h.inflight--
+	}
+
+	if info.Skipped {
+		return
+	}
+	h.requestCount++
+
+	// the weight of the history decays with the time since the last update.
+	w := 0.0
+	if h.lastDoneNs != 0 {
+		td := nowNs - h.lastDoneNs
+		if td < 0 {
+			td = 0
+		}
+		w = math.Exp(-float64(td) / float64(EWMA_DECAY_NS))
+	}
+	h.lastDoneNs = nowNs
+
+	success := 1.0
+	if info.Err != nil {
+		success = 0
+	}
+
+	h.latency = h.latency*w + float64(info.Latency)*(1-w)
+	h.clientSuccess = h.clientSuccess*w + success*(1-w)
+}
+
+/*
+load estimates the cost of sending one more request to the host.
+The slower, the busier and the more error-prone a host is, the higher its load.
+*/
+func (h *hostStat) load() float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	success := h.clientSuccess
+	if success < MIN_SUCCESS_RATIO {
+		success = MIN_SUCCESS_RATIO
+	}
+	return (h.latency + 1) * float64(h.inflight+1) / success
+}
+
+func (h *hostStat) getInflight() int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.inflight
+}
+
+func (h *hostStat) sincePick(nowNs int64) int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return nowNs - h.lastPickNs
+}
@@ -0,0 +1,256 @@
+package balancer
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EAHITechnology/raptor/utils"
+)
+
+const (
+	// size of the lookup table, must be a prime and much larger than the number of hosts.
+	MAGLEV_TABLE_SIZE = 65537
+
+	MAGLEV_WAIT_SORT = -1
+)
+
+type maglevHostInfo struct {
+	addr  string
+	wight int
+
+	*hostStat
+}
+
+func (m *maglevHostInfo) GetAddr() string {
+	return m.addr
+}
+
+func (m *maglevHostInfo) getWight() int {
+	return m.wight
+}
+
+func (m *maglevHostInfo) GetHost() (net.Conn, error) {
+	return m.getConn()
+}
+
+/*
+maglevBalancer is the consistent hashing of google maglev.
+Every host has a permutation of the lookup table slots, decided by
+offset = h1(addr) % M and skip = h2(addr) % (M-1) + 1.
+The hosts take turns to claim the next free slot of their permutations
+(a host claims `wight` slots every turn) until the table is full.
+
+Building the table is O(M) on Add and Remove, Pick is O(1).
+A change of the hosts only remaps a few keys besides the ones of the changed host.
+*/
+type maglevBalancer struct {
+	conf    balancerConfig
+	hosts   []*maglevHostInfo
+	table   []int // slot => index of hosts
+	lock    sync.RWMutex
+	addrMap map[string]int
+}
+
+func getMaglevHosts(items []balancerItem, stats map[string]*hostStat, poolConf *ConnPoolConfig) ([]*maglevHostInfo, map[string]int) {
+	hosts, addrMap := []*maglevHostInfo{}, make(map[string]int)
+	for idx, val := range items {
+		hosts = append(hosts, &maglevHostInfo{
+			addr:     val.addr,
+			wight:    val.wight,
+			hostStat: getHostStat(stats, val.addr, poolConf),
+		})
+		addrMap[val.addr] = idx
+	}
+	return hosts, addrMap
+}
+
+func getMaglevTable(hosts []*maglevHostInfo) []int {
+	total := 0
+	for _, host := range hosts {
+		if host.wight > 0 {
+			total += host.wight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	size := uint64(MAGLEV_TABLE_SIZE)
+	offsets, skips, next := make([]uint64, len(hosts)), make([]uint64, len(hosts)), make([]uint64, len(hosts))
+	for i, host := range hosts {
+		offsets[i] = utils.MurmurHash64A([]byte(host.addr)) % size
+		skips[i] = utils.MurmurHash64A([]byte(host.addr+"#skip"))%(size-1) + 1
+	}
+
+	table := make([]int, size)
+	for i := range table {
+		table[i] = -1
+	}
+
+	filled := uint64(0)
+	for filled < size {
+		for i, host := range hosts {
+			for w := 0; w < host.wight && filled < size; w++ {
+				slot := (offsets[i] + next[i]*skips[i]) % size
+				for table[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % size
+				}
+				table[slot] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+	return table
+}
+
+func NewMaglevBalancer(conf balancerConfig) (*maglevBalancer, error) {
+	if len(conf.balancerConfigs) == 0 {
+		return nil, errors.New("addr nil")
+	}
+
+	if len(conf.balancerConfigs) > MAGLEV_TABLE_SIZE/100 {
+		return nil, errors.New("addr too many")
+	}
+
+	hosts, addrMap := getMaglevHosts(conf.balancerConfigs, nil, conf.connPool)
+
+	return &maglevBalancer{
+		conf:    conf,
+		hosts:   hosts,
+		table:   getMaglevTable(hosts),
+		addrMap: addrMap,
+	}, nil
+}
+
+func (m *maglevBalancer) stats() map[string]*hostStat {
+	stats := make(map[string]*hostStat)
+	for _, host := range m.hosts {
+		stats[host.addr] = host.hostStat
+	}
+	return stats
+}
+
+func (m *maglevBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return m.pickFrom(key, nil)
+}
+
+/*
+nil available means all the hosts are available.
+The keys of an unavailable host walk to the next slots of the table,
+which are owned by the other hosts at random, so its keys spread over the available hosts.
+*/
+func (m *maglevBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if len(m.table) == 0 {
+		return nil, nil, errors.New("list is null")
+	}
+
+	slot := utils.MurmurHash64A(key) % uint64(len(m.table))
+	idx := m.table[slot]
+	if available != nil && !available(m.hosts[idx].addr) {
+		// every host is checked once, 1 means available, -1 means unavailable.
+		checked := make([]int, len(m.hosts))
+		checked[idx] = -1
+
+		idx = -1
+		for i := uint64(1); i < uint64(len(m.table)); i++ {
+			next := m.table[(slot+i)%uint64(len(m.table))]
+			if checked[next] == 0 {
+				checked[next] = -1
+				if available(m.hosts[next].addr) {
+					checked[next] = 1
+				}
+			}
+			if checked[next] == 1 {
+				idx = next
+				break
+			}
+		}
+
+		if idx < 0 {
+			return nil, nil, errNoAvailableHost
+		}
+	}
+
+	host := m.hosts[idx]
+	host.acquire(time.Now().UnixNano())
+	return host, host.done, nil
+}
+
+func (m *maglevBalancer) Add(conf ...balancerItem) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// only the new addrs count, updating the wight of a host never exceeds the limit.
+	added := make(map[string]bool)
+	for _, val := range conf {
+		if _, ok := m.addrMap[val.addr]; !ok {
+			added[val.addr] = true
+		}
+	}
+	if len(m.conf.balancerConfigs)+len(added) > MAGLEV_TABLE_SIZE/100 {
+		return errors.New("addr too many")
+	}
+
+	for _, val := range conf {
+		if i, ok := m.addrMap[val.addr]; ok {
+			// the addr appears more than once in conf, the first one wins.
+			if i == MAGLEV_WAIT_SORT {
+				continue
+			}
+			m.conf.balancerConfigs[i].wight = val.wight
+			continue
+		}
+
+		m.conf.balancerConfigs = append(m.conf.balancerConfigs, balancerItem{
+			addr:  val.addr,
+			wight: val.wight,
+		})
+		m.addrMap[val.addr] = MAGLEV_WAIT_SORT
+	}
+
+	m.hosts, m.addrMap = getMaglevHosts(m.conf.balancerConfigs, m.stats(), m.conf.connPool)
+	m.table = getMaglevTable(m.hosts)
+	return nil
+}
+
+func (m *maglevBalancer) Remove(addr string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.addrMap[addr]; !ok {
+		return errors.New("addr none")
+	}
+
+	tmpFront := m.conf.balancerConfigs[:m.addrMap[addr]]
+	tmpBackend := []balancerItem{}
+	if m.addrMap[addr] < len(m.conf.balancerConfigs)-1 {
+		tmpBackend = m.conf.balancerConfigs[m.addrMap[addr]+1:]
+	}
+	m.conf.balancerConfigs = []balancerItem{}
+	m.conf.balancerConfigs = append(m.conf.balancerConfigs, tmpFront...)
+	m.conf.balancerConfigs = append(m.conf.balancerConfigs, tmpBackend...)
+
+	stats := m.stats()
+	m.hosts, m.addrMap = getMaglevHosts(m.conf.balancerConfigs, stats, m.conf.connPool)
+	m.table = getMaglevTable(m.hosts)
+	closeHostStat(stats, addr)
+	return nil
+}
+
+func (m *maglevBalancer) Hosts() []HostInfo {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	hosts := []HostInfo{}
+	for _, host := range m.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
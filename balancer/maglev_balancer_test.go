@@ -0,0 +1,123 @@
+package balancer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMaglevBalancer(t *testing.T, hostNum int) *maglevBalancer {
+	list := []balancerItem{}
+	for i := 0; i < hostNum; i++ {
+		list = append(list, NewBalancerItem(fmt.Sprintf("xx.xxx.xxx.%02d", i), 1))
+	}
+
+	balancer, err := NewMaglevBalancer(balancerConfig{
+		balancerTyp:     MaglevType,
+		balancerConfigs: list,
+	})
+	assert.Nil(t, err)
+	return balancer
+}
+
+func pickMaglevAddrs(t *testing.T, b Balancer, keyNum int) []string {
+	addrs := []string{}
+	for i := 0; i < keyNum; i++ {
+		host, done, err := b.Pick([]byte(fmt.Sprintf("key_%d", i)))
+		assert.Nil(t, err)
+		done(DoneInfo{})
+		addrs = append(addrs, host.GetAddr())
+	}
+	return addrs
+}
+
+func TestNewMaglevBalancer(t *testing.T) {
+	balancer := newTestMaglevBalancer(t, 10)
+
+	// every host owns about 1/10 of the table.
+	count := map[int]int{}
+	for _, idx := range balancer.table {
+		count[idx]++
+	}
+	assert.Equal(t, len(count), 10)
+	for idx, num := range count {
+		assert.True(t, num > MAGLEV_TABLE_SIZE/10*9/10 && num < MAGLEV_TABLE_SIZE/10*11/10, idx)
+	}
+
+	// weights scale the share of the table.
+	assert.Nil(t, balancer.Add(NewBalancerItem("xx.xxx.xxx.00", 3)))
+	count = map[int]int{}
+	for _, idx := range balancer.table {
+		count[idx]++
+	}
+	assert.True(t, count[0] > count[1]*2)
+}
+
+func TestMaglevBalancer_RemoveRemap(t *testing.T) {
+	const keyNum = 100000
+	balancer := newTestMaglevBalancer(t, 10)
+	before := pickMaglevAddrs(t, balancer, keyNum)
+
+	removed := "xx.xxx.xxx.03"
+	assert.Nil(t, balancer.Remove(removed))
+	assert.NotNil(t, balancer.Remove(removed))
+	after := pickMaglevAddrs(t, balancer, keyNum)
+
+	ownedByRemoved, remapped, disrupted := 0, 0, 0
+	for i := 0; i < keyNum; i++ {
+		if before[i] == removed {
+			ownedByRemoved++
+		}
+		if before[i] != after[i] {
+			remapped++
+			if before[i] != removed {
+				disrupted++
+			}
+		}
+		assert.NotEqual(t, after[i], removed)
+	}
+	t.Logf("keys: %d, owned by removed host: %d, remapped: %d, remapped from other hosts: %d",
+		keyNum, ownedByRemoved, remapped, disrupted)
+
+	// the keys of the removed host have to move, the others should mostly stay.
+	assert.True(t, ownedByRemoved > keyNum/20)
+	assert.True(t, disrupted < keyNum/50)
+
+	// adding it back moves the keys back, the others should mostly stay.
+	assert.Nil(t, balancer.Add(NewBalancerItem(removed, 1)))
+	restored := pickMaglevAddrs(t, balancer, keyNum)
+	disrupted = 0
+	for i := 0; i < keyNum; i++ {
+		if before[i] != restored[i] {
+			disrupted++
+		}
+	}
+	t.Logf("remapped after adding it back: %d", disrupted)
+	assert.True(t, disrupted < keyNum/50)
+}
+
+func TestMaglevBalancer_AddDuplicate(t *testing.T) {
+	balancer := newTestMaglevBalancer(t, 2)
+
+	assert.Nil(t, balancer.Add(NewBalancerItem("xx.xxx.xxx.02", 1), NewBalancerItem("xx.xxx.xxx.02", 2)))
+	assert.Equal(t, len(balancer.Hosts()), 3)
+	assert.Equal(t, balancer.hosts[2].wight, 1)
+}
+
+func TestMaglevBalancer_AddAtLimit(t *testing.T) {
+	balancer := newTestMaglevBalancer(t, MAGLEV_TABLE_SIZE/100)
+
+	// updating the wights of the existing hosts at the limit.
+	assert.Nil(t, balancer.Add(NewBalancerItem("xx.xxx.xxx.00", 2), NewBalancerItem("xx.xxx.xxx.01", 2)))
+	assert.Equal(t, balancer.hosts[balancer.addrMap["xx.xxx.xxx.00"]].wight, 2)
+	assert.Equal(t, len(balancer.Hosts()), MAGLEV_TABLE_SIZE/100)
+
+	// a new addr exceeds the limit.
+	assert.NotNil(t, balancer.Add(NewBalancerItem("xx.xxx.xxx.a", 1)))
+
+	// a new addr appearing twice takes only one place.
+	assert.Nil(t, balancer.Remove("xx.xxx.xxx.00"))
+	assert.Nil(t, balancer.Add(NewBalancerItem("xx.xxx.xxx.a", 1), NewBalancerItem("xx.xxx.xxx.a", 1)))
+	assert.Equal(t, len(balancer.Hosts()), MAGLEV_TABLE_SIZE/100)
+}
@@ -0,0 +1,175 @@
+package balancer
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/EAHITechnology/raptor/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// reasons of the ejection.
+	ejectedByOutlier     = "outlier"
+	ejectedByHealthCheck = "health_check"
+)
+
+var (
+	balancerPicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "raptor",
+		Subsystem: "balancer",
+		Name:      "picks_total",
+		Help:      "Picks of every host.",
+	}, []string{"service", "addr"})
+
+	balancerPickErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "raptor",
+		Subsystem: "balancer",
+		Name:      "pick_errors_total",
+		Help:      "Errors returned by Pick.",
+	}, []string{"service"})
+
+	balancerHosts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raptor",
+		Subsystem: "balancer",
+		Name:      "hosts",
+		Help:      "Hosts in the balancer.",
+	}, []string{"service"})
+
+	balancerHostWight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raptor",
+		Subsystem: "balancer",
+		Name:      "host_wight",
+		Help:      "Configured wight of every host.",
+	}, []string{"service", "addr"})
+
+	balancerHostEjected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raptor",
+		Subsystem: "balancer",
+		Name:      "host_ejected",
+		Help:      "1 when the host is out of rotation, by outlier detection or health check.",
+	}, []string{"service", "addr", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(balancerPicks, balancerPickErrors, balancerHosts, balancerHostWight, balancerHostEjected)
+}
+
+func setHostEjected(serviceName, addr, reason string, ejected bool) {
+	value := 0.0
+	if ejected {
+		value = 1
+	}
+	balancerHostEjected.WithLabelValues(serviceName, addr, reason).Set(value)
+}
+
+func deleteHostEjected(serviceName, addr, reason string) {
+	balancerHostEjected.DeleteLabelValues(serviceName, addr, reason)
+}
+
+// the hosts of the balancers in this package report the wights in use.
+type wightHost interface {
+	getWight() int
+}
+
+/*
+metricsBalancer wraps a Balancer and exports the picks, the errors of Pick,
+and the hosts with their wights, labelled by the service name of balancerConfig.
+*/
+type metricsBalancer struct {
+	b           Balancer
+	serviceName string
+
+	lock sync.Mutex
+	// the hosts exported last time.
+	exported map[string]bool
+}
+
+func NewMetricsBalancer(b Balancer, conf balancerConfig) (*metricsBalancer, error) {
+	if b == nil || utils.IsNil(b) {
+		return nil, errors.New("balancer nil")
+	}
+
+	m := &metricsBalancer{
+		b:           b,
+		serviceName: conf.serviceName,
+		exported:    make(map[string]bool),
+	}
+	m.refresh()
+	return m, nil
+}
+
+/*
+export the hosts in the balancer, guarded by lock.
+The wights are read back from the hosts, the inner balancer may ignore some items of Add, eg: duplicate addrs.
+*/
+func (m *metricsBalancer) refresh() {
+	hosts := m.b.Hosts()
+	balancerHosts.WithLabelValues(m.serviceName).Set(float64(len(hosts)))
+
+	exported := make(map[string]bool)
+	for _, host := range hosts {
+		exported[host.GetAddr()] = true
+		if wh, ok := host.(wightHost); ok {
+			balancerHostWight.WithLabelValues(m.serviceName, host.GetAddr()).Set(float64(wh.getWight()))
+		}
+	}
+
+	for addr := range m.exported {
+		if !exported[addr] {
+			balancerHostWight.DeleteLabelValues(m.serviceName, addr)
+			balancerPicks.DeleteLabelValues(m.serviceName, addr)
+		}
+	}
+	m.exported = exported
+}
+
+func (m *metricsBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return m.count(m.b.Pick(key))
+}
+
+func (m *metricsBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	host, done, err := pickFrom(m.b, key, available)
+	if err == errNoAvailableHost {
+		// the caller falls back to Pick, which counts the pick.
+		return nil, nil, err
+	}
+	return m.count(host, done, err)
+}
+
+func (m *metricsBalancer) count(host HostInfo, done DoneFunc, err error) (HostInfo, DoneFunc, error) {
+	if err != nil {
+		balancerPickErrors.WithLabelValues(m.serviceName).Inc()
+		return nil, nil, err
+	}
+
+	balancerPicks.WithLabelValues(m.serviceName, host.GetAddr()).Inc()
+	return host, done, nil
+}
+
+func (m *metricsBalancer) Add(conf ...balancerItem) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if err := m.b.Add(conf...); err != nil {
+		return err
+	}
+	m.refresh()
+	return nil
+}
+
+func (m *metricsBalancer) Remove(addr string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if err := m.b.Remove(addr); err != nil {
+		return err
+	}
+
+	m.refresh()
+	return nil
+}
+
+func (m *metricsBalancer) Hosts() []HostInfo {
+	return m.b.Hosts()
+}
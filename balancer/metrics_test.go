@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsBalancer(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp:     RoundRobinType,
+		balancerConfigs: []balancerItem{NewBalancerItem("a", 1), NewBalancerItem("b", 3)},
+	}
+	conf.SetServiceName("test_metrics")
+
+	b, err := NewBalancer(conf)
+	assert.Nil(t, err)
+	m, err := NewMetricsBalancer(b, conf)
+	assert.Nil(t, err)
+
+	assert.Equal(t, testutil.ToFloat64(balancerHosts.WithLabelValues("test_metrics")), 2.0)
+	assert.Equal(t, testutil.ToFloat64(balancerHostWight.WithLabelValues("test_metrics", "b")), 3.0)
+
+	for i := 0; i < 8; i++ {
+		_, done, err := m.Pick(nil)
+		assert.Nil(t, err)
+		done(DoneInfo{})
+	}
+	assert.Equal(t, testutil.ToFloat64(balancerPicks.WithLabelValues("test_metrics", "a")), 2.0)
+	assert.Equal(t, testutil.ToFloat64(balancerPicks.WithLabelValues("test_metrics", "b")), 6.0)
+
+	assert.Nil(t, m.Add(NewBalancerItem("c", 2)))
+	assert.Equal(t, testutil.ToFloat64(balancerHosts.WithLabelValues("test_metrics")), 3.0)
+	assert.Equal(t, testutil.ToFloat64(balancerHostWight.WithLabelValues("test_metrics", "c")), 2.0)
+
+	// the series of the removed host are deleted.
+	assert.Nil(t, m.Remove("a"))
+	assert.Equal(t, testutil.ToFloat64(balancerHosts.WithLabelValues("test_metrics")), 2.0)
+	assert.Equal(t, testutil.CollectAndCount(balancerHostWight, "raptor_balancer_host_wight"), 2)
+
+	// the errors of Pick.
+	r, err := NewRangeBalancer(balancerConfig{
+		balancerTyp:     RangeType,
+		balancerConfigs: []balancerItem{NewRangeBalancerItem("a", 1, "a", "b")},
+		serviceName:     "test_metrics_range",
+	})
+	assert.Nil(t, err)
+	m, err = NewMetricsBalancer(r, r.conf)
+	assert.Nil(t, err)
+	_, _, err = m.Pick([]byte("z"))
+	assert.NotNil(t, err)
+	assert.Equal(t, testutil.ToFloat64(balancerPickErrors.WithLabelValues("test_metrics_range")), 1.0)
+}
+
+func TestMetricsBalancer_AddDuplicate(t *testing.T) {
+	for _, typ := range []BalancerTyp{P2cType, RoundRobinType, MaglevType, ConsistencyHashType} {
+		serviceName := "test_metrics_duplicate_" + string(typ)
+		conf := balancerConfig{
+			balancerTyp:     typ,
+			balancerConfigs: []balancerItem{NewBalancerItem("a", 1)},
+			serviceName:     serviceName,
+		}
+
+		b, err := NewBalancer(conf)
+		assert.Nil(t, err)
+		m, err := NewMetricsBalancer(b, conf)
+		assert.Nil(t, err)
+
+		// the wights in use are exported, not the ones passed to Add.
+		assert.Nil(t, m.Add(NewBalancerItem("b", 2), NewBalancerItem("b", 5)))
+		assert.Equal(t, testutil.ToFloat64(balancerHostWight.WithLabelValues(serviceName, "b")), 2.0, typ)
+
+		if typ == ConsistencyHashType {
+			// the existing addr is not updated.
+			assert.Nil(t, m.Add(NewBalancerItem("a", 4)))
+			assert.Equal(t, testutil.ToFloat64(balancerHostWight.WithLabelValues(serviceName, "a")), 1.0, typ)
+		}
+	}
+}
+
+func TestOutlierDetectionBalancer_Metrics(t *testing.T) {
+	o := newTestOutlierDetectionBalancer(t, NewOutlierDetectionConfig().
+		SetServiceName("test_outlier_metrics").
+		SetConsecutiveFailures(1))
+
+	sendOutlierRequests(t, o, 4, "a")
+	assert.Equal(t, testutil.ToFloat64(balancerHostEjected.WithLabelValues("test_outlier_metrics", "a", ejectedByOutlier)), 1.0)
+}
@@ -0,0 +1,343 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/EAHITechnology/raptor/utils"
+)
+
+const (
+	outlierTotalHit   = "total"
+	outlierFailureHit = "failure"
+
+	// cells of the failure rate window.
+	OUTLIER_WINDOW_SIZE = 10
+)
+
+// OutlierClass decides how the outcome of a request counts for the outlier detection.
+type OutlierClass int
+
+const (
+	OutlierClassFailure OutlierClass = iota
+	// eg: 4xx, the host works as expected.
+	OutlierClassSuccess
+	// not counted, eg: the caller canceled the request.
+	OutlierClassIgnore
+)
+
+// OutlierErrorClassifier classifies the DoneInfo.Err of a request.
+type OutlierErrorClassifier func(err error) OutlierClass
+
+/*
+DefaultOutlierErrorClassifier ignores the requests canceled by the caller.
+An error with a `StatusCode() int` method is a failure only for the 5xx status,
+the other errors, eg: timeouts, dial and conn errors, are failures.
+*/
+func DefaultOutlierErrorClassifier(err error) OutlierClass {
+	if err == nil {
+		return OutlierClassSuccess
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return OutlierClassIgnore
+	}
+
+	var statusErr interface{ StatusCode() int }
+	if errors.As(err, &statusErr) && statusErr.StatusCode() < http.StatusInternalServerError {
+		return OutlierClassSuccess
+	}
+	return OutlierClassFailure
+}
+
+type OutlierDetectionConfig struct {
+	serviceName          string // label of the metrics
+	consecutiveFailures  int64  // failures in a row
+	failureRateThreshold int64  // failure rate in percent
+	minRequests          int64
+	intervalMs           int64
+	baseEjectionMs       int64
+	maxEjectionMs        int64
+	maxEjectionPercent   int64
+	// DefaultOutlierErrorClassifier when nil.
+	errorClassifier OutlierErrorClassifier
+}
+
+// outlierHost is the detection state of a host.
+type outlierHost struct {
+	consecutiveFailures int64
+	sw                  *utils.SlidingWindow
+
+	ejected        bool
+	ejectedMs      int64
+	ejectedUntilMs int64
+	// times the host has been ejected in a row, the ejection time doubles every time.
+	ejectedTimes int64
+	returnedMs   int64
+}
+
+/*
+outlierDetectionBalancer wraps a Balancer and ejects the hosts that keep failing.
+
+The errors of the requests are classified by `errorClassifier`, eg: 4xx is not a failure.
+A host is ejected when it fails `consecutiveFailures` times in a row,
+or when its failure rate in the last `intervalMs` exceeds `failureRateThreshold`
+with at least `minRequests` requests.
+The ejection lasts baseEjectionMs * 2^(n-1) (at most maxEjectionMs) for the n-th ejection in a row,
+then the host returns to rotation automatically.
+At most `maxEjectionPercent` percent of the hosts (at least one) are ejected at the same time.
+Pick never picks the ejected hosts, unless all the hosts are ejected.
+*/
+type outlierDetectionBalancer struct {
+	b     Balancer
+	conf  OutlierDetectionConfig
+	lock  sync.Mutex
+	hosts map[string]*outlierHost
+}
+
+/*
+the default config ejects a host after 5 consecutive failures for 30s, 60s, 120s ... at most 300s.
+the failure rate detection is disabled by default.
+*/
+func NewOutlierDetectionConfig() *OutlierDetectionConfig {
+	return &OutlierDetectionConfig{
+		consecutiveFailures: 5,
+		minRequests:         10,
+		intervalMs:          10000,
+		baseEjectionMs:      30000,
+		maxEjectionMs:       300000,
+		maxEjectionPercent:  10,
+	}
+}
+
+func (c *OutlierDetectionConfig) SetServiceName(serviceName string) *OutlierDetectionConfig {
+	c.serviceName = serviceName
+	return c
+}
+
+// 0 means the consecutive failures detection is disabled.
+func (c *OutlierDetectionConfig) SetConsecutiveFailures(consecutiveFailures int64) *OutlierDetectionConfig {
+	c.consecutiveFailures = consecutiveFailures
+	return c
+}
+
+// 0 means the failure rate detection is disabled.
+func (c *OutlierDetectionConfig) SetFailureRateThreshold(failureRateThreshold int64) *OutlierDetectionConfig {
+	c.failureRateThreshold = failureRateThreshold
+	return c
+}
+
+func (c *OutlierDetectionConfig) SetMinRequests(minRequests int64) *OutlierDetectionConfig {
+	c.minRequests = minRequests
+	return c
+}
+
+func (c *OutlierDetectionConfig) SetIntervalMs(intervalMs int64) *OutlierDetectionConfig {
+	c.intervalMs = intervalMs
+	return c
+}
+
+func (c *OutlierDetectionConfig) SetBaseEjectionMs(baseEjectionMs int64) *OutlierDetectionConfig {
+	c.baseEjectionMs = baseEjectionMs
+	return c
+}
+
+func (c *OutlierDetectionConfig) SetMaxEjectionMs(maxEjectionMs int64) *OutlierDetectionConfig {
+	c.maxEjectionMs = maxEjectionMs
+	return c
+}
+
+func (c *OutlierDetectionConfig) SetMaxEjectionPercent(maxEjectionPercent int64) *OutlierDetectionConfig {
+	c.maxEjectionPercent = maxEjectionPercent
+	return c
+}
+
+func (c *OutlierDetectionConfig) SetErrorClassifier(errorClassifier OutlierErrorClassifier) *OutlierDetectionConfig {
+	c.errorClassifier = errorClassifier
+	return c
+}
+
+func NewOutlierDetectionBalancer(b Balancer, conf *OutlierDetectionConfig) (*outlierDetectionBalancer, error) {
+	if b == nil || utils.IsNil(b) {
+		return nil, errors.New("balancer nil")
+	}
+
+	if conf.intervalMs < OUTLIER_WINDOW_SIZE || conf.baseEjectionMs <= 0 {
+		return nil, errors.New("outlier detection config illegal")
+	}
+
+	if conf.maxEjectionMs < conf.baseEjectionMs {
+		conf.maxEjectionMs = conf.baseEjectionMs
+	}
+
+	o := &outlierDetectionBalancer{
+		b:     b,
+		conf:  *conf,
+		hosts: make(map[string]*outlierHost),
+	}
+	if o.conf.errorClassifier == nil {
+		o.conf.errorClassifier = DefaultOutlierErrorClassifier
+	}
+	return o, nil
+}
+
+func (o *outlierDetectionBalancer) getHost(addr string) *outlierHost {
+	host, ok := o.hosts[addr]
+	if !ok {
+		host = &outlierHost{
+			sw: utils.NewSlidingWindow(OUTLIER_WINDOW_SIZE, o.conf.intervalMs/OUTLIER_WINDOW_SIZE),
+		}
+		o.hosts[addr] = host
+	}
+	return host
+}
+
+// return the host to rotation once the ejection expires, guarded by lock.
+func (o *outlierDetectionBalancer) refresh(addr string, host *outlierHost, nowMs int64) {
+	if host.ejected && nowMs >= host.ejectedUntilMs {
+		host.ejected = false
+		setHostEjected(o.conf.serviceName, addr, ejectedByOutlier, false)
+		host.returnedMs = nowMs
+		host.consecutiveFailures = 0
+		for _, cell := range host.sw.Cells {
+			cell.Reset()
+		}
+	}
+
+	// the host has stayed in rotation long enough, forget its ejections.
+	if !host.ejected && host.ejectedTimes > 0 && nowMs-host.returnedMs > o.conf.maxEjectionMs {
+		host.ejectedTimes = 0
+	}
+}
+
+// IsAvailable reports whether the host is in rotation.
+func (o *outlierDetectionBalancer) IsAvailable(addr string) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	host, ok := o.hosts[addr]
+	if !ok {
+		return true
+	}
+
+	o.refresh(addr, host, utils.GetNowMs())
+	return !host.ejected
+}
+
+// hostNum is the number of the hosts in the balancer, guarded by lock.
+func (o *outlierDetectionBalancer) canEject(nowMs int64, hostNum int) bool {
+	ejected := int64(0)
+	for addr, host := range o.hosts {
+		o.refresh(addr, host, nowMs)
+		if host.ejected {
+			ejected++
+		}
+	}
+
+	maxEjected := int64(hostNum) * o.conf.maxEjectionPercent / 100
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+	return ejected < maxEjected
+}
+
+func (o *outlierDetectionBalancer) report(addr string, err error) {
+	switch o.conf.errorClassifier(err) {
+	case OutlierClassIgnore:
+		return
+	case OutlierClassSuccess:
+		err = nil
+	}
+
+	// count the hosts out of the lock, Hosts takes the lock of the inner balancer,
+	// which may call IsAvailable while holding it.
+	hostNum := 0
+	if err != nil {
+		hostNum = len(o.b.Hosts())
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	nowMs := utils.GetNowMs()
+	host := o.getHost(addr)
+	o.refresh(addr, host, nowMs)
+	if host.ejected {
+		// the request was sent before the host was ejected.
+		return
+	}
+
+	if err == nil {
+		host.consecutiveFailures = 0
+		host.sw.Hit(nowMs, outlierTotalHit)
+		return
+	}
+
+	host.consecutiveFailures++
+	host.sw.Hit(nowMs, outlierTotalHit, outlierFailureHit)
+
+	outlier := o.conf.consecutiveFailures > 0 && host.consecutiveFailures >= o.conf.consecutiveFailures
+	if !outlier && o.conf.failureRateThreshold > 0 {
+		hitsStat := host.sw.GetHits(nowMs, outlierTotalHit, outlierFailureHit)
+		totalHits, failureHits := hitsStat[outlierTotalHit], hitsStat[outlierFailureHit]
+		outlier = totalHits >= o.conf.minRequests && failureHits*100 > o.conf.failureRateThreshold*totalHits
+	}
+
+	if !outlier || !o.canEject(nowMs, hostNum) {
+		return
+	}
+
+	host.ejectedTimes++
+	ejectionMs := o.conf.maxEjectionMs
+	if host.ejectedTimes <= 32 && o.conf.baseEjectionMs<<(host.ejectedTimes-1) < o.conf.maxEjectionMs {
+		ejectionMs = o.conf.baseEjectionMs << (host.ejectedTimes - 1)
+	}
+	host.ejected = true
+	host.ejectedMs = nowMs
+	host.ejectedUntilMs = nowMs + ejectionMs
+	setHostEjected(o.conf.serviceName, addr, ejectedByOutlier, true)
+}
+
+func (o *outlierDetectionBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return o.reportDone(pickAvailable(o.b, key, o.IsAvailable))
+}
+
+func (o *outlierDetectionBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	return o.reportDone(pickFrom(o.b, key, allAvailable(available, o.IsAvailable)))
+}
+
+// the outcome of the picked host is reported to the detection.
+func (o *outlierDetectionBalancer) reportDone(host HostInfo, done DoneFunc, err error) (HostInfo, DoneFunc, error) {
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return host, func(info DoneInfo) {
+		if !info.Skipped {
+			o.report(host.GetAddr(), info.Err)
+		}
+		done(info)
+	}, nil
+}
+
+func (o *outlierDetectionBalancer) Add(conf ...balancerItem) error {
+	return o.b.Add(conf...)
+}
+
+func (o *outlierDetectionBalancer) Remove(addr string) error {
+	if err := o.b.Remove(addr); err != nil {
+		return err
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	delete(o.hosts, addr)
+	deleteHostEjected(o.conf.serviceName, addr, ejectedByOutlier)
+	return nil
+}
+
+func (o *outlierDetectionBalancer) Hosts() []HostInfo {
+	return o.b.Hosts()
+}
@@ -0,0 +1,232 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOutlierDetectionBalancer(t *testing.T, conf *OutlierDetectionConfig) *outlierDetectionBalancer {
+	b, err := NewRoundRobinBalancer(balancerConfig{
+		balancerTyp: RoundRobinType,
+		balancerConfigs: []balancerItem{
+			NewBalancerItem("a", 1),
+			NewBalancerItem("b", 1),
+			NewBalancerItem("c", 1),
+			NewBalancerItem("d", 1),
+		},
+	})
+	assert.Nil(t, err)
+
+	o, err := NewOutlierDetectionBalancer(b, conf)
+	assert.Nil(t, err)
+	return o
+}
+
+// send requests, the hosts in `bad` always fail.
+func sendOutlierRequests(t *testing.T, b Balancer, num int, bad ...string) map[string]int {
+	count := map[string]int{}
+	for i := 0; i < num; i++ {
+		host, done, err := b.Pick(nil)
+		assert.Nil(t, err)
+		count[host.GetAddr()]++
+
+		var reqErr error
+		for _, addr := range bad {
+			if host.GetAddr() == addr {
+				reqErr = errors.New("just_error")
+			}
+		}
+		done(DoneInfo{Err: reqErr, Latency: time.Millisecond})
+	}
+	return count
+}
+
+func TestOutlierDetectionBalancer_ConsecutiveFailures(t *testing.T) {
+	o := newTestOutlierDetectionBalancer(t, NewOutlierDetectionConfig().
+		SetConsecutiveFailures(3).
+		SetBaseEjectionMs(100).
+		SetMaxEjectionMs(1000))
+
+	// "a" fails 3 times in the first 12 requests, then it is ejected.
+	sendOutlierRequests(t, o, 12, "a")
+	assert.False(t, o.IsAvailable("a"))
+	assert.True(t, o.IsAvailable("b"))
+
+	count := sendOutlierRequests(t, o, 30)
+	assert.Equal(t, count["a"], 0)
+
+	// the ejection expires.
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, o.IsAvailable("a"))
+	count = sendOutlierRequests(t, o, 40)
+	assert.True(t, count["a"] > 0)
+}
+
+type testStatusError int
+
+func (e testStatusError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+func (e testStatusError) StatusCode() int {
+	return int(e)
+}
+
+func TestOutlierDetectionBalancer_ErrorClassifier(t *testing.T) {
+	newBalancer := func(classifier OutlierErrorClassifier) *outlierDetectionBalancer {
+		return newTestOutlierDetectionBalancer(t, NewOutlierDetectionConfig().
+			SetConsecutiveFailures(3).
+			SetMaxEjectionPercent(100).
+			SetErrorClassifier(classifier))
+	}
+
+	// the 4xx responses and the canceled requests never eject the host.
+	for _, err := range []error{
+		testStatusError(404),
+		fmt.Errorf("request : %w", testStatusError(429)),
+		context.Canceled,
+		fmt.Errorf("request : %w", context.Canceled),
+	} {
+		o := newBalancer(nil)
+		for i := 0; i < 10; i++ {
+			o.report("a", err)
+		}
+		assert.True(t, o.IsAvailable("a"), err.Error())
+	}
+
+	// the 5xx responses, the timeouts and the conn errors eject the host.
+	for _, err := range []error{
+		testStatusError(503),
+		context.DeadlineExceeded,
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		errors.New("just_error"),
+	} {
+		o := newBalancer(nil)
+		for i := 0; i < 3; i++ {
+			o.report("a", err)
+		}
+		assert.False(t, o.IsAvailable("a"), err.Error())
+	}
+
+	// the 4xx response breaks the failures in a row.
+	o := newBalancer(nil)
+	o.report("a", testStatusError(500))
+	o.report("a", testStatusError(500))
+	o.report("a", testStatusError(400))
+	o.report("a", testStatusError(500))
+	assert.True(t, o.IsAvailable("a"))
+
+	o = newBalancer(func(err error) OutlierClass {
+		return OutlierClassIgnore
+	})
+	for i := 0; i < 10; i++ {
+		o.report("a", testStatusError(500))
+	}
+	assert.True(t, o.IsAvailable("a"))
+}
+
+func TestOutlierDetectionBalancer_EjectionBackoff(t *testing.T) {
+	o := newTestOutlierDetectionBalancer(t, NewOutlierDetectionConfig().
+		SetConsecutiveFailures(1).
+		SetBaseEjectionMs(50).
+		SetMaxEjectionMs(150))
+
+	ejectionMs := func() int64 {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+		host := o.hosts["a"]
+		return host.ejectedUntilMs - host.ejectedMs
+	}
+
+	sendOutlierRequests(t, o, 4, "a")
+	assert.False(t, o.IsAvailable("a"))
+
+	// the second ejection in a row lasts twice as long, and the third one is capped.
+	for _, expect := range []int64{100, 150} {
+		time.Sleep(time.Duration(ejectionMs()+20) * time.Millisecond)
+		assert.True(t, o.IsAvailable("a"))
+		sendOutlierRequests(t, o, 4, "a")
+		assert.False(t, o.IsAvailable("a"))
+		assert.Equal(t, ejectionMs(), expect)
+	}
+}
+
+func TestOutlierDetectionBalancer_FailureRate(t *testing.T) {
+	o := newTestOutlierDetectionBalancer(t, NewOutlierDetectionConfig().
+		SetConsecutiveFailures(0).
+		SetFailureRateThreshold(40).
+		SetMinRequests(10))
+
+	// "a" is picked 3 times and fails 2 of them, fewer than the min requests.
+	for i := 0; i < 10; i++ {
+		host, done, err := o.Pick(nil)
+		assert.Nil(t, err)
+		if host.GetAddr() == "a" && i%8 == 0 {
+			done(DoneInfo{Err: errors.New("just_error")})
+		} else {
+			done(DoneInfo{})
+		}
+	}
+	assert.True(t, o.IsAvailable("a"))
+
+	sendOutlierRequests(t, o, 40, "a")
+	assert.False(t, o.IsAvailable("a"))
+}
+
+func TestOutlierDetectionBalancer_MaxEjectionPercent(t *testing.T) {
+	o := newTestOutlierDetectionBalancer(t, NewOutlierDetectionConfig().
+		SetConsecutiveFailures(1).
+		SetMaxEjectionPercent(50))
+
+	// at most 2 of the 4 hosts are ejected.
+	sendOutlierRequests(t, o, 40, "a", "b", "c")
+	ejected := 0
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		if !o.IsAvailable(addr) {
+			ejected++
+		}
+	}
+	assert.Equal(t, ejected, 2)
+
+	assert.Nil(t, o.Remove("a"))
+	assert.Equal(t, len(o.Hosts()), 3)
+}
+
+func TestOutlierDetectionBalancer_MostEjected(t *testing.T) {
+	list := []balancerItem{}
+	bad := []string{}
+	for i := 0; i < 10; i++ {
+		addr := fmt.Sprintf("xx.xxx.xxx.%02d", i)
+		list = append(list, NewBalancerItem(addr, 1))
+		if i > 0 {
+			bad = append(bad, addr)
+		}
+	}
+
+	for _, typ := range []BalancerTyp{RandomType, P2cType, ConsistencyHashType} {
+		b, err := NewBalancer(balancerConfig{balancerTyp: typ, balancerConfigs: list})
+		assert.Nil(t, err)
+		o, err := NewOutlierDetectionBalancer(b, NewOutlierDetectionConfig().
+			SetConsecutiveFailures(1).
+			SetMaxEjectionPercent(90))
+		assert.Nil(t, err)
+
+		for _, addr := range bad {
+			o.report(addr, errors.New("just_error"))
+		}
+
+		// 9 of the 10 hosts are ejected, only the last one is picked.
+		for i := 0; i < 1000; i++ {
+			host, done, err := o.Pick([]byte(fmt.Sprintf("key_%d", i)))
+			assert.Nil(t, err)
+			assert.Equal(t, host.GetAddr(), "xx.xxx.xxx.00", typ)
+			done(DoneInfo{})
+		}
+	}
+}
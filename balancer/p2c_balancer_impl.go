@@ -1 +1,241 @@
 package balancer
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EAHITechnology/raptor/utils/rand2"
+)
+
+const (
+	P2C_WAIT_SORT = -1
+
+	// times to retry when the two candidates are the same host.
+	P2C_PICK_RETRY = 3
+)
+
+type p2cHostInfo struct {
+	addr  string
+	wight int
+
+	*hostStat
+}
+
+func (p *p2cHostInfo) GetAddr() string {
+	return p.addr
+}
+
+func (p *p2cHostInfo) getWight() int {
+	return p.wight
+}
+
+func (p *p2cHostInfo) GetHost() (net.Conn, error) {
+	return p.getConn()
+}
+
+// the lower the score, the better the host.
+func (p *p2cHostInfo) score() float64 {
+	return p.load() / float64(p.wight)
+}
+
+/*
+p2cBalancer is the power of two choices balancer.
+It picks two hosts at random according to their weights,
+then chooses the one with the lower load.
+A host in slow start accepts to be a candidate with the probability of its slow start factor.
+*/
+type p2cBalancer struct {
+	conf    balancerConfig
+	hosts   []*p2cHostInfo
+	prefix  []int // prefix sums of the weights, used by the weighted random
+	lock    sync.RWMutex
+	rand    *rand2.Rand
+	addrMap map[string]int
+}
+
+func getP2cHosts(items []balancerItem, stats map[string]*hostStat, poolConf *ConnPoolConfig) ([]*p2cHostInfo, []int, map[string]int) {
+	hosts, prefix, addrMap := []*p2cHostInfo{}, []int{}, make(map[string]int)
+
+	sum := 0
+	for idx, val := range items {
+		hosts = append(hosts, &p2cHostInfo{
+			addr:     val.addr,
+			wight:    val.wight,
+			hostStat: getHostStat(stats, val.addr, poolConf),
+		})
+
+		sum += val.wight
+		prefix = append(prefix, sum)
+		addrMap[val.addr] = idx
+	}
+	return hosts, prefix, addrMap
+}
+
+func NewP2cBalancer(conf balancerConfig) (*p2cBalancer, error) {
+	if len(conf.balancerConfigs) == 0 {
+		return nil, errors.New("addr nil")
+	}
+
+	hosts, prefix, addrMap := getP2cHosts(conf.balancerConfigs, nil, conf.connPool)
+
+	return &p2cBalancer{
+		conf:    conf,
+		hosts:   hosts,
+		prefix:  prefix,
+		rand:    rand2.New(rand.NewSource(time.Now().UnixNano())),
+		addrMap: addrMap,
+	}, nil
+}
+
+func (p *p2cBalancer) stats() map[string]*hostStat {
+	stats := make(map[string]*hostStat)
+	for _, host := range p.hosts {
+		stats[host.addr] = host.hostStat
+	}
+	return stats
+}
+
+func (p *p2cBalancer) weightedIndex(hosts []*p2cHostInfo, prefix []int, nowNs int64) int {
+	total := prefix[len(prefix)-1]
+
+	idx := 0
+	for i := 0; i < SLOW_START_PICK_RETRY; i++ {
+		r := p.rand.Intn(total)
+		idx = sort.Search(len(prefix), func(i int) bool {
+			return prefix[i] > r
+		})
+		if p.rand.Float64() < p.conf.slowStartFactor(hosts[idx].hostStat, nowNs) {
+			break
+		}
+	}
+	return idx
+}
+
+// the two candidates, the same host when there is only one.
+func (p *p2cBalancer) candidates(hosts []*p2cHostInfo, prefix []int, nowNs int64) (*p2cHostInfo, *p2cHostInfo) {
+	a, b := p.weightedIndex(hosts, prefix, nowNs), p.weightedIndex(hosts, prefix, nowNs)
+	for i := 0; a == b && i < P2C_PICK_RETRY; i++ {
+		b = p.weightedIndex(hosts, prefix, nowNs)
+	}
+	return hosts[a], hosts[b]
+}
+
+func (p *p2cBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return p.pickFrom(key, nil)
+}
+
+// nil available means all the hosts are available.
+func (p *p2cBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if len(p.hosts) == 0 {
+		return nil, nil, errors.New("list is null")
+	}
+
+	total := p.prefix[len(p.prefix)-1]
+	if total <= 0 {
+		return nil, nil, errors.New("wight is zero")
+	}
+
+	nowNs := time.Now().UnixNano()
+
+	pc, upc := p.candidates(p.hosts, p.prefix, nowNs)
+	if available != nil && (!available(pc.addr) || !available(upc.addr)) {
+		// choose the candidates again among the available hosts.
+		hosts, prefix, sum := []*p2cHostInfo{}, []int{}, 0
+		for _, host := range p.hosts {
+			if host.wight <= 0 || !available(host.addr) {
+				continue
+			}
+			sum += host.wight
+			hosts = append(hosts, host)
+			prefix = append(prefix, sum)
+		}
+
+		if len(hosts) == 0 {
+			return nil, nil, errNoAvailableHost
+		}
+		pc, upc = p.candidates(hosts, prefix, nowNs)
+	}
+
+	if pc == upc {
+		pc.acquire(nowNs)
+		return pc, pc.done, nil
+	}
+
+	if pc.score() > upc.score() {
+		pc, upc = upc, pc
+	}
+
+	// the loser has not been picked for a long time, its statistics may be stale.
+	if upc.sincePick(nowNs) > FORCE_PICK_NS {
+		pc = upc
+	}
+
+	pc.acquire(nowNs)
+	return pc, pc.done, nil
+}
+
+func (p *p2cBalancer) Add(conf ...balancerItem) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, val := range conf {
+		if i, ok := p.addrMap[val.addr]; ok {
+			// the addr appears more than once in conf, the first one wins.
+			if i == P2C_WAIT_SORT {
+				continue
+			}
+			p.conf.balancerConfigs[i].wight = val.wight
+			continue
+		}
+
+		p.conf.balancerConfigs = append(p.conf.balancerConfigs, balancerItem{
+			addr:  val.addr,
+			wight: val.wight,
+		})
+		p.addrMap[val.addr] = P2C_WAIT_SORT
+	}
+
+	p.hosts, p.prefix, p.addrMap = getP2cHosts(p.conf.balancerConfigs, p.stats(), p.conf.connPool)
+	return nil
+}
+
+func (p *p2cBalancer) Remove(addr string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.addrMap[addr]; !ok {
+		return errors.New("addr none")
+	}
+
+	tmpFront := p.conf.balancerConfigs[:p.addrMap[addr]]
+	tmpBackend := []balancerItem{}
+	if p.addrMap[addr] < len(p.conf.balancerConfigs)-1 {
+		tmpBackend = p.conf.balancerConfigs[p.addrMap[addr]+1:]
+	}
+	p.conf.balancerConfigs = []balancerItem{}
+	p.conf.balancerConfigs = append(p.conf.balancerConfigs, tmpFront...)
+	p.conf.balancerConfigs = append(p.conf.balancerConfigs, tmpBackend...)
+
+	stats := p.stats()
+	p.hosts, p.prefix, p.addrMap = getP2cHosts(p.conf.balancerConfigs, stats, p.conf.connPool)
+	closeHostStat(stats, addr)
+	return nil
+}
+
+func (p *p2cBalancer) Hosts() []HostInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	hosts := []HostInfo{}
+	for _, host := range p.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
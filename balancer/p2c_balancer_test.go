@@ -0,0 +1,83 @@
+package balancer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewP2cBalancer(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: P2cType,
+		balancerConfigs: []balancerItem{
+			NewBalancerItem("xx.xxx.xxx.01", 1),
+			NewBalancerItem("xx.xxx.xxx.02", 1),
+		},
+	}
+	balancer, err := NewP2cBalancer(conf)
+	assert.Nil(t, err)
+
+	// xx.xxx.xxx.01 is slow and always fails.
+	for i := 0; i < 100; i++ {
+		host, done, err := balancer.Pick(nil)
+		assert.Nil(t, err)
+		if host.GetAddr() == "xx.xxx.xxx.01" {
+			done(DoneInfo{Err: errors.New("just_error"), Latency: 100 * time.Millisecond})
+		} else {
+			done(DoneInfo{Latency: time.Millisecond})
+		}
+	}
+
+	count := map[string]int{}
+	for i := 0; i < 100; i++ {
+		host, done, err := balancer.Pick(nil)
+		assert.Nil(t, err)
+		count[host.GetAddr()]++
+		done(DoneInfo{Latency: time.Millisecond})
+	}
+	assert.True(t, count["xx.xxx.xxx.02"] > count["xx.xxx.xxx.01"])
+}
+
+func TestP2cBalancer_AddRemove(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp:     P2cType,
+		balancerConfigs: []balancerItem{NewBalancerItem("xx.xxx.xxx.01", 1)},
+	}
+	balancer, err := NewP2cBalancer(conf)
+	assert.Nil(t, err)
+
+	assert.Nil(t, balancer.Add(NewBalancerItem("xx.xxx.xxx.02", 1)))
+	assert.Nil(t, balancer.Remove("xx.xxx.xxx.01"))
+	assert.NotNil(t, balancer.Remove("xx.xxx.xxx.01"))
+
+	for i := 0; i < 10; i++ {
+		host, done, err := balancer.Pick(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, host.GetAddr(), "xx.xxx.xxx.02")
+		done(DoneInfo{})
+	}
+
+	assert.Nil(t, balancer.Remove("xx.xxx.xxx.02"))
+	_, _, err = balancer.Pick(nil)
+	assert.NotNil(t, err)
+}
+
+func TestP2cBalancer_AddDuplicate(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp:     P2cType,
+		balancerConfigs: []balancerItem{NewBalancerItem("xx.xxx.xxx.01", 1)},
+	}
+	balancer, err := NewP2cBalancer(conf)
+	assert.Nil(t, err)
+
+	assert.Nil(t, balancer.Add(NewBalancerItem("xx.xxx.xxx.02", 1), NewBalancerItem("xx.xxx.xxx.02", 2)))
+	assert.Equal(t, len(balancer.Hosts()), 2)
+	assert.Equal(t, balancer.conf.balancerConfigs[1].wight, 1)
+
+	random, err := NewRandomBalancer(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, random.Add(NewBalancerItem("xx.xxx.xxx.02", 1), NewBalancerItem("xx.xxx.xxx.02", 2)))
+	assert.Equal(t, len(random.Hosts()), 2)
+}
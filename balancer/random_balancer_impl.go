@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/EAHITechnology/raptor/utils/rand2"
 )
@@ -17,45 +18,53 @@ type randomHostInfo struct {
 	addr  string
 	wight int
 
-	//todo
-	inflight      int
-	clientSuccess int
-	requestCount  int
-	latency       float64
+	*hostStat
 }
 
 func (r *randomHostInfo) GetAddr() string {
 	return r.addr
 }
 
+func (r *randomHostInfo) getWight() int {
+	return r.wight
+}
+
 func (r *randomHostInfo) GetHost() (net.Conn, error) {
-	return nil, nil
+	return r.getConn()
 }
 
+/*
+randomBalancer picks a host at random according to the wights.
+A host in slow start accepts a pick with the probability of its slow start factor,
+so it takes wight * factor of the traffic.
+*/
 type randomBalancer struct {
 	conf                     balancerConfig
 	geometricProbabilityList []*randomHostInfo
+	hosts                    []*randomHostInfo
 	lock                     sync.RWMutex
 	rand                     *rand2.Rand
 	addrMap                  map[string]int
 }
 
-func getGplist(items []balancerItem) ([]*randomHostInfo, map[string]int) {
-	gplist, addrMap := []*randomHostInfo{}, make(map[string]int)
+func getGplist(items []balancerItem, stats map[string]*hostStat, poolConf *ConnPoolConfig) ([]*randomHostInfo, []*randomHostInfo, map[string]int) {
+	gplist, hosts, addrMap := []*randomHostInfo{}, []*randomHostInfo{}, make(map[string]int)
 
 	for idx, val := range items {
 		hostinfo := &randomHostInfo{
-			addr:  val.addr,
-			wight: val.wight,
+			addr:     val.addr,
+			wight:    val.wight,
+			hostStat: getHostStat(stats, val.addr, poolConf),
 		}
 
 		addrMap[val.addr] = idx
+		hosts = append(hosts, hostinfo)
 
 		for idx := val.wight; idx > 0; idx-- {
 			gplist = append(gplist, hostinfo)
 		}
 	}
-	return gplist, addrMap
+	return gplist, hosts, addrMap
 }
 
 func NewRandomBalancer(conf balancerConfig) (*randomBalancer, error) {
@@ -64,29 +73,74 @@ func NewRandomBalancer(conf balancerConfig) (*randomBalancer, error) {
 		return nil, errors.New("addr nil")
 	}
 
-	gplist, addrMap := getGplist(conf.balancerConfigs)
+	gplist, hosts, addrMap := getGplist(conf.balancerConfigs, nil, conf.connPool)
 
 	return &randomBalancer{
 		conf:                     conf,
 		geometricProbabilityList: gplist,
+		hosts:                    hosts,
 		rand:                     rand2.New(rand.NewSource(1)),
 		addrMap:                  addrMap,
 	}, nil
 }
 
-func (r *randomBalancer) Pick(key []byte) (HostInfo, error) {
+func (r *randomBalancer) stats() map[string]*hostStat {
+	stats := make(map[string]*hostStat)
+	for _, host := range r.hosts {
+		stats[host.addr] = host.hostStat
+	}
+	return stats
+}
+
+func (r *randomBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return r.pickFrom(key, nil)
+}
+
+func (r *randomBalancer) pickGplist(gplist []*randomHostInfo, nowNs int64) *randomHostInfo {
+	host := gplist[0]
+	if len(gplist) > 1 {
+		for i := 0; i < SLOW_START_PICK_RETRY; i++ {
+			host = gplist[r.rand.Int()%len(gplist)]
+			if r.rand.Float64() < r.conf.slowStartFactor(host.hostStat, nowNs) {
+				break
+			}
+		}
+	}
+	return host
+}
+
+// nil available means all the hosts are available.
+func (r *randomBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
 	if len(r.geometricProbabilityList) == 0 {
-		return nil, errors.New("list is null")
+		return nil, nil, errors.New("list is null")
 	}
 
-	if len(r.geometricProbabilityList) == 1 {
-		return r.geometricProbabilityList[0], nil
+	nowNs := time.Now().UnixNano()
+
+	host := r.pickGplist(r.geometricProbabilityList, nowNs)
+	if available != nil && !available(host.addr) {
+		// pick again among the available hosts, the wights keep their proportions.
+		gplist := []*randomHostInfo{}
+		for _, val := range r.hosts {
+			if val.wight <= 0 || !available(val.addr) {
+				continue
+			}
+			for idx := val.wight; idx > 0; idx-- {
+				gplist = append(gplist, val)
+			}
+		}
+
+		if len(gplist) == 0 {
+			return nil, nil, errNoAvailableHost
+		}
+		host = r.pickGplist(gplist, nowNs)
 	}
 
-	return r.geometricProbabilityList[r.rand.Int()%len(r.geometricProbabilityList)], nil
+	host.acquire(nowNs)
+	return host, host.done, nil
 }
 
 func (r *randomBalancer) Add(conf ...balancerItem) error {
@@ -95,6 +149,10 @@ func (r *randomBalancer) Add(conf ...balancerItem) error {
 
 	for _, val := range conf {
 		if i, ok := r.addrMap[val.addr]; ok {
+			// the addr appears more than once in conf, the first one wins.
+			if i == RANDOM_WAIT_SORT {
+				continue
+			}
 			r.conf.balancerConfigs[i].wight = val.wight
 			continue
 		}
@@ -106,7 +164,7 @@ func (r *randomBalancer) Add(conf ...balancerItem) error {
 		r.addrMap[val.addr] = RANDOM_WAIT_SORT
 	}
 
-	r.geometricProbabilityList, r.addrMap = getGplist(r.conf.balancerConfigs)
+	r.geometricProbabilityList, r.hosts, r.addrMap = getGplist(r.conf.balancerConfigs, r.stats(), r.conf.connPool)
 	return nil
 }
 
@@ -127,6 +185,19 @@ func (r *randomBalancer) Remove(addr string) error {
 	r.conf.balancerConfigs = append(r.conf.balancerConfigs, tmpFront...)
 	r.conf.balancerConfigs = append(r.conf.balancerConfigs, tmpBackend...)
 
-	r.geometricProbabilityList, r.addrMap = getGplist(r.conf.balancerConfigs)
+	stats := r.stats()
+	r.geometricProbabilityList, r.hosts, r.addrMap = getGplist(r.conf.balancerConfigs, stats, r.conf.connPool)
+	closeHostStat(stats, addr)
 	return nil
 }
+
+func (r *randomBalancer) Hosts() []HostInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	hosts := []HostInfo{}
+	for _, host := range r.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
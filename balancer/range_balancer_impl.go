@@ -0,0 +1,267 @@
+package balancer
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+type rangeHostInfo struct {
+	addr  string
+	wight int
+
+	*hostStat
+}
+
+func (r *rangeHostInfo) GetAddr() string {
+	return r.addr
+}
+
+func (r *rangeHostInfo) getWight() int {
+	return r.wight
+}
+
+func (r *rangeHostInfo) GetHost() (net.Conn, error) {
+	return r.getConn()
+}
+
+// rangeSegment is a contiguous key range [start, end) owned by a host.
+type rangeSegment struct {
+	start string
+	end   string
+	host  *rangeHostInfo
+}
+
+/*
+rangeBalancer routes a key to the host owning the key range.
+The segments are sorted by start and never overlap.
+
+Add splits: the new range is carved out of the segments it overlaps.
+Remove merges: the ranges of the removed host are taken over by the neighbor
+before it, or the neighbor after it when there is no neighbor before.
+Adjacent segments owned by the same host are always merged.
+*/
+type rangeBalancer struct {
+	conf     balancerConfig
+	segments []rangeSegment
+	hosts    map[string]*rangeHostInfo
+	lock     sync.RWMutex
+}
+
+// is the key below the end of a range, empty end means no upper bound.
+func keyBelowEnd(key, end string) bool {
+	return end == "" || key < end
+}
+
+// compare two range ends, empty end means no upper bound.
+func endLess(a, b string) bool {
+	if a == "" {
+		return false
+	}
+	return b == "" || a < b
+}
+
+func checkRange(item balancerItem) error {
+	if item.rangeEnd != "" && item.rangeStart >= item.rangeEnd {
+		return errors.New("illegal range")
+	}
+	return nil
+}
+
+// carve [start, end) out of the segments.
+func carveSegments(segments []rangeSegment, start, end string) []rangeSegment {
+	carved := []rangeSegment{}
+	for _, seg := range segments {
+		if !keyBelowEnd(start, seg.end) || !keyBelowEnd(seg.start, end) {
+			carved = append(carved, seg)
+			continue
+		}
+
+		if seg.start < start {
+			carved = append(carved, rangeSegment{start: seg.start, end: start, host: seg.host})
+		}
+
+		if endLess(end, seg.end) {
+			carved = append(carved, rangeSegment{start: end, end: seg.end, host: seg.host})
+		}
+	}
+	return carved
+}
+
+// sort the segments and merge the adjacent ones owned by the same host.
+func mergeSegments(segments []rangeSegment) []rangeSegment {
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start < segments[j].start
+	})
+
+	merged := []rangeSegment{}
+	for _, seg := range segments {
+		n := len(merged)
+		if n > 0 && merged[n-1].host == seg.host && merged[n-1].end == seg.start {
+			merged[n-1].end = seg.end
+			continue
+		}
+		merged = append(merged, seg)
+	}
+	return merged
+}
+
+// the hosts and the config are derived from the segments.
+func getRangeHosts(segments []rangeSegment) (map[string]*rangeHostInfo, []balancerItem) {
+	hosts, items := make(map[string]*rangeHostInfo), []balancerItem{}
+	for _, seg := range segments {
+		hosts[seg.host.addr] = seg.host
+		items = append(items, NewRangeBalancerItem(seg.host.addr, seg.host.wight, seg.start, seg.end))
+	}
+	return hosts, items
+}
+
+func NewRangeBalancer(conf balancerConfig) (*rangeBalancer, error) {
+	if len(conf.balancerConfigs) == 0 {
+		return nil, errors.New("addr nil")
+	}
+
+	r := &rangeBalancer{
+		conf:  conf,
+		hosts: make(map[string]*rangeHostInfo),
+	}
+
+	if err := r.Add(conf.balancerConfigs...); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rangeBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return r.pickFrom(key, nil)
+}
+
+/*
+nil available means all the hosts are available.
+A key is owned by a single host, there is no other host to take it when the owner is unavailable.
+*/
+func (r *rangeBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.segments) == 0 {
+		return nil, nil, errors.New("list is null")
+	}
+
+	k := string(key)
+	idx := sort.Search(len(r.segments), func(i int) bool {
+		return r.segments[i].start > k
+	}) - 1
+
+	if idx < 0 || !keyBelowEnd(k, r.segments[idx].end) {
+		return nil, nil, errors.New("key out of range")
+	}
+
+	host := r.segments[idx].host
+	if available != nil && !available(host.addr) {
+		return nil, nil, errNoAvailableHost
+	}
+
+	host.acquire(time.Now().UnixNano())
+	return host, host.done, nil
+}
+
+func (r *rangeBalancer) Add(conf ...balancerItem) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, val := range conf {
+		if err := checkRange(val); err != nil {
+			return err
+		}
+	}
+
+	segments := r.segments
+	for _, val := range conf {
+		host, ok := r.hosts[val.addr]
+		if ok {
+			host.wight = val.wight
+		} else {
+			host = &rangeHostInfo{
+				addr:     val.addr,
+				wight:    val.wight,
+				hostStat: getHostStat(nil, val.addr, r.conf.connPool),
+			}
+			r.hosts[val.addr] = host
+		}
+
+		segments = carveSegments(segments, val.rangeStart, val.rangeEnd)
+		segments = append(segments, rangeSegment{start: val.rangeStart, end: val.rangeEnd, host: host})
+	}
+
+	r.segments = mergeSegments(segments)
+	old := r.hosts
+	r.hosts, r.conf.balancerConfigs = getRangeHosts(r.segments)
+
+	// the hosts whose ranges are all carved away are removed.
+	for addr, host := range old {
+		if _, ok := r.hosts[addr]; !ok && host.pool != nil {
+			host.pool.close()
+		}
+	}
+	return nil
+}
+
+func (r *rangeBalancer) Remove(addr string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	removed, ok := r.hosts[addr]
+	if !ok {
+		return errors.New("addr none")
+	}
+
+	segments := []rangeSegment{}
+	// a removed range that has no neighbor before it, waiting for the neighbor after it.
+	var pending *rangeSegment
+	for _, seg := range r.segments {
+		if seg.host.addr == addr {
+			n := len(segments)
+			if n > 0 && segments[n-1].end == seg.start {
+				segments[n-1].end = seg.end
+			} else if pending != nil && pending.end == seg.start {
+				pending.end = seg.end
+			} else {
+				pending = &rangeSegment{start: seg.start, end: seg.end}
+			}
+			continue
+		}
+
+		if pending != nil && pending.end == seg.start {
+			seg.start = pending.start
+		}
+		pending = nil
+		segments = append(segments, seg)
+	}
+
+	r.segments = mergeSegments(segments)
+	r.hosts, r.conf.balancerConfigs = getRangeHosts(r.segments)
+	if removed.pool != nil {
+		removed.pool.close()
+	}
+	return nil
+}
+
+func (r *rangeBalancer) Hosts() []HostInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	// a host may own several segments.
+	hosts, seen := []HostInfo{}, make(map[string]bool)
+	for _, seg := range r.segments {
+		if seen[seg.host.addr] {
+			continue
+		}
+		seen[seg.host.addr] = true
+		hosts = append(hosts, seg.host)
+	}
+	return hosts
+}
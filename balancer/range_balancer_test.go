@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pickRangeAddr(b Balancer, key string) string {
+	host, done, err := b.Pick([]byte(key))
+	if err != nil {
+		return ""
+	}
+	done(DoneInfo{})
+	return host.GetAddr()
+}
+
+func TestNewRangeBalancer(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: RangeType,
+		balancerConfigs: []balancerItem{
+			NewRangeBalancerItem("xx.xxx.xxx.01", 1, "", "g"),
+			NewRangeBalancerItem("xx.xxx.xxx.02", 1, "g", "p"),
+			NewRangeBalancerItem("xx.xxx.xxx.03", 1, "p", ""),
+		},
+	}
+	balancer, err := NewRangeBalancer(conf)
+	assert.Nil(t, err)
+
+	assert.Equal(t, pickRangeAddr(balancer, "apple"), "xx.xxx.xxx.01")
+	assert.Equal(t, pickRangeAddr(balancer, "g"), "xx.xxx.xxx.02")
+	assert.Equal(t, pickRangeAddr(balancer, "orange"), "xx.xxx.xxx.02")
+	assert.Equal(t, pickRangeAddr(balancer, "zebra"), "xx.xxx.xxx.03")
+
+	_, err = NewRangeBalancer(balancerConfig{
+		balancerTyp:     RangeType,
+		balancerConfigs: []balancerItem{NewRangeBalancerItem("xx.xxx.xxx.01", 1, "p", "g")},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestRangeBalancer_Gap(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: RangeType,
+		balancerConfigs: []balancerItem{
+			NewRangeBalancerItem("xx.xxx.xxx.01", 1, "a", "g"),
+			NewRangeBalancerItem("xx.xxx.xxx.02", 1, "p", "t"),
+		},
+	}
+	balancer, err := NewRangeBalancer(conf)
+	assert.Nil(t, err)
+
+	_, _, err = balancer.Pick([]byte("0"))
+	assert.NotNil(t, err)
+	_, _, err = balancer.Pick([]byte("k"))
+	assert.NotNil(t, err)
+	_, _, err = balancer.Pick([]byte("z"))
+	assert.NotNil(t, err)
+}
+
+func TestRangeBalancer_SplitMerge(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: RangeType,
+		balancerConfigs: []balancerItem{
+			NewRangeBalancerItem("xx.xxx.xxx.01", 1, "", "m"),
+			NewRangeBalancerItem("xx.xxx.xxx.02", 1, "m", ""),
+		},
+	}
+	balancer, err := NewRangeBalancer(conf)
+	assert.Nil(t, err)
+
+	// split [m, +inf) into [m, p) [p, t) [t, +inf)
+	assert.Nil(t, balancer.Add(NewRangeBalancerItem("xx.xxx.xxx.03", 1, "p", "t")))
+	assert.Equal(t, pickRangeAddr(balancer, "n"), "xx.xxx.xxx.02")
+	assert.Equal(t, pickRangeAddr(balancer, "q"), "xx.xxx.xxx.03")
+	assert.Equal(t, pickRangeAddr(balancer, "u"), "xx.xxx.xxx.02")
+	assert.Equal(t, len(balancer.segments), 4)
+
+	// merge [p, t) into the neighbor before it.
+	assert.Nil(t, balancer.Remove("xx.xxx.xxx.03"))
+	assert.Equal(t, pickRangeAddr(balancer, "q"), "xx.xxx.xxx.02")
+	assert.Equal(t, len(balancer.segments), 2)
+	assert.NotNil(t, balancer.Remove("xx.xxx.xxx.03"))
+
+	// the first range has no neighbor before it, merge into the neighbor after it.
+	assert.Nil(t, balancer.Remove("xx.xxx.xxx.01"))
+	assert.Equal(t, pickRangeAddr(balancer, "a"), "xx.xxx.xxx.02")
+	assert.Equal(t, len(balancer.segments), 1)
+
+	// a range covering everything takes over all the keys.
+	assert.Nil(t, balancer.Add(NewRangeBalancerItem("xx.xxx.xxx.04", 1, "", "")))
+	assert.Equal(t, pickRangeAddr(balancer, "a"), "xx.xxx.xxx.04")
+	assert.Equal(t, len(balancer.hosts), 1)
+}
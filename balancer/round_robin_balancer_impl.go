@@ -0,0 +1,200 @@
+package balancer
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	ROUND_ROBIN_WAIT_SORT = -1
+)
+
+type roundRobinHostInfo struct {
+	addr         string
+	wight        int
+	currentWight int
+
+	*hostStat
+}
+
+func (r *roundRobinHostInfo) GetAddr() string {
+	return r.addr
+}
+
+func (r *roundRobinHostInfo) getWight() int {
+	return r.wight
+}
+
+func (r *roundRobinHostInfo) GetHost() (net.Conn, error) {
+	return r.getConn()
+}
+
+/*
+roundRobinBalancer is the smooth weighted round-robin balancer of nginx.
+Every Pick adds each host's wight to its currentWight, picks the host with the
+largest currentWight, then subtracts the total wight from the picked one.
+With wights {5, 1, 1} the picks are {a, a, b, a, c, a, a}.
+
+The hosts keep their currentWight across Add and Remove,
+so a rebalance does not restart the sequence.
+A host in slow start takes part with its effective wight.
+*/
+type roundRobinBalancer struct {
+	conf    balancerConfig
+	hosts   []*roundRobinHostInfo
+	lock    sync.Mutex
+	addrMap map[string]int
+}
+
+func getRoundRobinHosts(items []balancerItem, old []*roundRobinHostInfo, poolConf *ConnPoolConfig) ([]*roundRobinHostInfo, map[string]int) {
+	oldHosts := make(map[string]*roundRobinHostInfo)
+	var stats map[string]*hostStat
+	if old != nil {
+		stats = make(map[string]*hostStat)
+	}
+	for _, host := range old {
+		oldHosts[host.addr] = host
+		stats[host.addr] = host.hostStat
+	}
+
+	hosts, addrMap := []*roundRobinHostInfo{}, make(map[string]int)
+	for idx, val := range items {
+		host, ok := oldHosts[val.addr]
+		if !ok {
+			host = &roundRobinHostInfo{
+				addr:     val.addr,
+				hostStat: getHostStat(stats, val.addr, poolConf),
+			}
+		}
+		host.wight = val.wight
+
+		hosts = append(hosts, host)
+		addrMap[val.addr] = idx
+	}
+	return hosts, addrMap
+}
+
+func NewRoundRobinBalancer(conf balancerConfig) (*roundRobinBalancer, error) {
+	if len(conf.balancerConfigs) == 0 {
+		return nil, errors.New("addr nil")
+	}
+
+	hosts, addrMap := getRoundRobinHosts(conf.balancerConfigs, nil, conf.connPool)
+
+	return &roundRobinBalancer{
+		conf:    conf,
+		hosts:   hosts,
+		addrMap: addrMap,
+	}, nil
+}
+
+func (r *roundRobinBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return r.pickFrom(key, nil)
+}
+
+// nil available means all the hosts are available, the unavailable hosts keep their currentWight.
+func (r *roundRobinBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.hosts) == 0 {
+		return nil, nil, errors.New("list is null")
+	}
+
+	nowNs := time.Now().UnixNano()
+
+	var best *roundRobinHostInfo
+	total, skipped := 0, 0
+	for _, host := range r.hosts {
+		if host.wight <= 0 {
+			continue
+		}
+
+		if available != nil && !available(host.addr) {
+			skipped++
+			continue
+		}
+
+		wight := r.conf.slowStartWight(host.wight, host.hostStat, nowNs)
+		host.currentWight += wight
+		total += wight
+		if best == nil || host.currentWight > best.currentWight {
+			best = host
+		}
+	}
+
+	if best == nil && skipped > 0 {
+		return nil, nil, errNoAvailableHost
+	}
+
+	if best == nil {
+		return nil, nil, errors.New("wight is zero")
+	}
+
+	best.currentWight -= total
+	best.acquire(nowNs)
+	return best, best.done, nil
+}
+
+func (r *roundRobinBalancer) Add(conf ...balancerItem) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, val := range conf {
+		if i, ok := r.addrMap[val.addr]; ok {
+			// the addr appears more than once in conf, the first one wins.
+			if i == ROUND_ROBIN_WAIT_SORT {
+				continue
+			}
+			r.conf.balancerConfigs[i].wight = val.wight
+			continue
+		}
+
+		r.conf.balancerConfigs = append(r.conf.balancerConfigs, balancerItem{
+			addr:  val.addr,
+			wight: val.wight,
+		})
+		r.addrMap[val.addr] = ROUND_ROBIN_WAIT_SORT
+	}
+
+	r.hosts, r.addrMap = getRoundRobinHosts(r.conf.balancerConfigs, r.hosts, r.conf.connPool)
+	return nil
+}
+
+func (r *roundRobinBalancer) Remove(addr string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.addrMap[addr]; !ok {
+		return errors.New("addr none")
+	}
+
+	tmpFront := r.conf.balancerConfigs[:r.addrMap[addr]]
+	tmpBackend := []balancerItem{}
+	if r.addrMap[addr] < len(r.conf.balancerConfigs)-1 {
+		tmpBackend = r.conf.balancerConfigs[r.addrMap[addr]+1:]
+	}
+	r.conf.balancerConfigs = []balancerItem{}
+	r.conf.balancerConfigs = append(r.conf.balancerConfigs, tmpFront...)
+	r.conf.balancerConfigs = append(r.conf.balancerConfigs, tmpBackend...)
+
+	removed := r.hosts[r.addrMap[addr]]
+	r.hosts, r.addrMap = getRoundRobinHosts(r.conf.balancerConfigs, r.hosts, r.conf.connPool)
+	if removed.pool != nil {
+		removed.pool.close()
+	}
+	return nil
+}
+
+func (r *roundRobinBalancer) Hosts() []HostInfo {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	hosts := []HostInfo{}
+	for _, host := range r.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
@@ -0,0 +1,83 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRoundRobinBalancer(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: RoundRobinType,
+		balancerConfigs: []balancerItem{
+			NewBalancerItem("a", 5),
+			NewBalancerItem("b", 1),
+			NewBalancerItem("c", 1),
+		},
+	}
+	balancer, err := NewRoundRobinBalancer(conf)
+	assert.Nil(t, err)
+
+	picks := []string{}
+	for i := 0; i < 7; i++ {
+		host, done, err := balancer.Pick(nil)
+		assert.Nil(t, err)
+		done(DoneInfo{})
+		picks = append(picks, host.GetAddr())
+	}
+	assert.Equal(t, picks, []string{"a", "a", "b", "a", "c", "a", "a"})
+}
+
+func TestRoundRobinBalancer_AddRemove(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp: RoundRobinType,
+		balancerConfigs: []balancerItem{
+			NewBalancerItem("a", 2),
+			NewBalancerItem("b", 1),
+		},
+	}
+	balancer, err := NewRoundRobinBalancer(conf)
+	assert.Nil(t, err)
+
+	host, done, err := balancer.Pick(nil)
+	assert.Nil(t, err)
+	done(DoneInfo{})
+	assert.Equal(t, host.GetAddr(), "a")
+
+	// the current position survives the rebalance.
+	assert.Nil(t, balancer.Add(NewBalancerItem("c", 3), NewBalancerItem("d", 0)))
+	assert.Equal(t, balancer.hosts[0].currentWight, -1)
+
+	count := map[string]int{}
+	for i := 0; i < 600; i++ {
+		host, done, err := balancer.Pick(nil)
+		assert.Nil(t, err)
+		done(DoneInfo{})
+		count[host.GetAddr()]++
+	}
+	assert.Equal(t, count["a"], 200)
+	assert.Equal(t, count["b"], 100)
+	assert.Equal(t, count["c"], 300)
+	assert.Equal(t, count["d"], 0)
+
+	assert.Nil(t, balancer.Remove("c"))
+	assert.NotNil(t, balancer.Remove("c"))
+	for i := 0; i < 30; i++ {
+		host, done, err := balancer.Pick(nil)
+		assert.Nil(t, err)
+		done(DoneInfo{})
+		assert.NotEqual(t, host.GetAddr(), "c")
+	}
+}
+
+func TestRoundRobinBalancer_AddDuplicate(t *testing.T) {
+	balancer, err := NewRoundRobinBalancer(balancerConfig{
+		balancerTyp:     RoundRobinType,
+		balancerConfigs: []balancerItem{NewBalancerItem("a", 1)},
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, balancer.Add(NewBalancerItem("b", 1), NewBalancerItem("b", 2)))
+	assert.Equal(t, len(balancer.Hosts()), 2)
+	assert.Equal(t, balancer.hosts[1].wight, 1)
+}
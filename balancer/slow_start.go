@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// the effective wight of a new host starts from this fraction of its wight.
+	SLOW_START_MIN_WIGHT = 0.1
+
+	// times to pick again when a host in slow start rejects the pick.
+	SLOW_START_PICK_RETRY = 10
+)
+
+// slowStartFactor is the fraction of the wight a host takes, in [SLOW_START_MIN_WIGHT, 1].
+func (b *balancerConfig) slowStartFactor(stat *hostStat, nowNs int64) float64 {
+	if b.slowStartMs <= 0 || stat.addedNs == 0 {
+		return 1
+	}
+
+	window := b.slowStartMs * int64(time.Millisecond)
+	elapsed := nowNs - stat.addedNs
+	if elapsed >= window {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	aggression := b.slowStartAggression
+	if aggression <= 0 {
+		aggression = 1
+	}
+
+	factor := math.Pow(float64(elapsed)/float64(window), 1/aggression)
+	if factor < SLOW_START_MIN_WIGHT {
+		factor = SLOW_START_MIN_WIGHT
+	}
+	return factor
+}
+
+// slowStartWight is the effective wight of a host, at least 1 if wight is positive.
+func (b *balancerConfig) slowStartWight(wight int, stat *hostStat, nowNs int64) int {
+	if wight <= 0 {
+		return wight
+	}
+
+	effective := int(math.Ceil(float64(wight) * b.slowStartFactor(stat, nowNs)))
+	if effective < 1 {
+		effective = 1
+	}
+	return effective
+}
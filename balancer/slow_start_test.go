@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	conf := balancerConfig{}
+	conf.SetSlowStart(1000, 1)
+
+	nowNs := time.Now().UnixNano()
+	stat := newHostStat()
+	assert.Equal(t, conf.slowStartFactor(stat, nowNs), 1.0)
+
+	stat.addedNs = nowNs
+	assert.Equal(t, conf.slowStartFactor(stat, nowNs), SLOW_START_MIN_WIGHT)
+	assert.InDelta(t, conf.slowStartFactor(stat, nowNs+int64(500*time.Millisecond)), 0.5, 1e-9)
+	assert.Equal(t, conf.slowStartFactor(stat, nowNs+int64(time.Second)), 1.0)
+
+	// a larger aggression ramps faster at the beginning.
+	conf.SetSlowStart(1000, 2)
+	assert.InDelta(t, conf.slowStartFactor(stat, nowNs+int64(250*time.Millisecond)), 0.5, 1e-9)
+
+	assert.Equal(t, conf.slowStartWight(10, stat, nowNs), 1)
+	assert.Equal(t, conf.slowStartWight(10, stat, nowNs+int64(250*time.Millisecond)), 5)
+}
+
+func TestRandomBalancer_SlowStart(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp:     RandomType,
+		balancerConfigs: []balancerItem{NewBalancerItem("a", 1)},
+	}
+	conf.SetSlowStart(200, 1)
+
+	b, err := NewRandomBalancer(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Add(NewBalancerItem("b", 1)))
+
+	count := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		host, done, err := b.Pick(nil)
+		assert.Nil(t, err)
+		count[host.GetAddr()]++
+		done(DoneInfo{})
+	}
+	t.Log(count)
+	assert.True(t, count["b"] < 200)
+
+	time.Sleep(200 * time.Millisecond)
+	count = map[string]int{}
+	for i := 0; i < 1000; i++ {
+		host, done, err := b.Pick(nil)
+		assert.Nil(t, err)
+		count[host.GetAddr()]++
+		done(DoneInfo{})
+	}
+	t.Log(count)
+	assert.True(t, count["b"] > 400)
+}
+
+func TestRoundRobinBalancer_SlowStart(t *testing.T) {
+	conf := balancerConfig{
+		balancerTyp:     RoundRobinType,
+		balancerConfigs: []balancerItem{NewBalancerItem("a", 10)},
+	}
+	conf.SetSlowStart(200, 1)
+
+	b, err := NewRoundRobinBalancer(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Add(NewBalancerItem("b", 10)))
+
+	// the effective wight of "b" is 1 at the beginning.
+	count := map[string]int{}
+	for i := 0; i < 11; i++ {
+		host, done, err := b.Pick(nil)
+		assert.Nil(t, err)
+		count[host.GetAddr()]++
+		done(DoneInfo{})
+	}
+	assert.True(t, count["b"] <= 2)
+
+	time.Sleep(200 * time.Millisecond)
+	count = map[string]int{}
+	for i := 0; i < 20; i++ {
+		host, done, err := b.Pick(nil)
+		assert.Nil(t, err)
+		count[host.GetAddr()]++
+		done(DoneInfo{})
+	}
+	assert.Equal(t, count["b"], 10)
+}
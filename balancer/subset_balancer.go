@@ -0,0 +1,206 @@
+package balancer
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/EAHITechnology/raptor/utils"
+)
+
+/*
+subsetBalancer keeps a stable subset of `size` hosts for the client,
+the inner balancer of `balancerTyp` only sees the hosts in the subset.
+
+The subset is chosen by deterministic subsetting: the clients are grouped into rounds of len(hosts)/size,
+the hosts are ordered by rendezvous hashing, scored by hash(round, addr),
+and the clients of a round take the consecutive subsets of the order by their index in the round.
+The index of the client is the number at the end of the clientId, eg: the pod "user-3" of a stateful set,
+a clientId not ending with a number is hashed to the index.
+When the clients have consecutive indexes and the hosts are a multiple of size,
+every host serves the same number of clients, ±1.
+Adding or removing a host shifts the order by one, so it replaces at most one host of a subset,
+unless len(hosts)/size changes and the client moves to another round.
+*/
+type subsetBalancer struct {
+	b           Balancer
+	clientIndex uint64
+	size        int
+
+	items  map[string]balancerItem
+	subset map[string]bool
+	lock   sync.Mutex
+}
+
+type subsetScore struct {
+	addr  string
+	score uint64
+}
+
+func NewSubsetBalancer(conf balancerConfig, clientId string, size int) (*subsetBalancer, error) {
+	if len(conf.balancerConfigs) == 0 {
+		return nil, errors.New("addr nil")
+	}
+
+	if size <= 0 {
+		return nil, errors.New("subset size illegal")
+	}
+
+	// the key ranges are global, they can not be subset.
+	if conf.balancerTyp == RangeType {
+		return nil, errors.New("illegal type")
+	}
+
+	s := &subsetBalancer{
+		clientIndex: getClientIndex(clientId),
+		size:        size,
+		items:       make(map[string]balancerItem),
+	}
+	for _, val := range conf.balancerConfigs {
+		s.items[val.addr] = val
+	}
+	s.subset = s.getSubset()
+
+	subsetConf := conf
+	subsetConf.balancerConfigs = []balancerItem{}
+	for _, val := range conf.balancerConfigs {
+		if s.subset[val.addr] {
+			subsetConf.balancerConfigs = append(subsetConf.balancerConfigs, val)
+		}
+	}
+
+	b, err := NewBalancer(subsetConf)
+	if err != nil {
+		return nil, err
+	}
+	s.b = b
+	return s, nil
+}
+
+// the number after the last "-" of the clientId, eg: "user-3" is 3, or the hash of the clientId.
+func getClientIndex(clientId string) uint64 {
+	if index, err := strconv.ParseUint(clientId[strings.LastIndex(clientId, "-")+1:], 10, 64); err == nil {
+		return index
+	}
+	return utils.MurmurHash64A([]byte(clientId))
+}
+
+// guarded by lock.
+func (s *subsetBalancer) getSubset() map[string]bool {
+	subset := make(map[string]bool)
+	subsetCount := uint64(len(s.items) / s.size)
+	if subsetCount == 0 {
+		// less hosts than the subset size.
+		for addr := range s.items {
+			subset[addr] = true
+		}
+		return subset
+	}
+
+	// the clients of the same round order the hosts the same way.
+	round := strconv.FormatUint(s.clientIndex/subsetCount, 10)
+	scores := make([]subsetScore, 0, len(s.items))
+	for addr := range s.items {
+		scores = append(scores, subsetScore{
+			addr:  addr,
+			score: utils.MurmurHash64A([]byte(round + "#" + addr)),
+		})
+	}
+
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].score != scores[j].score {
+			return scores[i].score > scores[j].score
+		}
+		return scores[i].addr < scores[j].addr
+	})
+
+	offset := int(s.clientIndex % subsetCount)
+	for _, score := range scores[offset*s.size : (offset+1)*s.size] {
+		subset[score.addr] = true
+	}
+	return subset
+}
+
+/*
+apply the new subset to the inner balancer, guarded by lock.
+The hosts joining are added before the hosts leaving are removed,
+so the inner balancer is never empty.
+*/
+func (s *subsetBalancer) reset(updated map[string]bool) error {
+	subset := s.getSubset()
+
+	joining := []balancerItem{}
+	for addr := range subset {
+		if !s.subset[addr] || updated[addr] {
+			joining = append(joining, s.items[addr])
+		}
+	}
+	if len(joining) > 0 {
+		if err := s.b.Add(joining...); err != nil {
+			return err
+		}
+	}
+
+	for addr := range s.subset {
+		if subset[addr] {
+			continue
+		}
+		if err := s.b.Remove(addr); err != nil {
+			return err
+		}
+	}
+
+	s.subset = subset
+	return nil
+}
+
+// Subset returns the addrs in the subset of the client.
+func (s *subsetBalancer) Subset() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	addrs := []string{}
+	for addr := range s.subset {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (s *subsetBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return s.b.Pick(key)
+}
+
+func (s *subsetBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	return pickFrom(s.b, key, available)
+}
+
+func (s *subsetBalancer) Add(conf ...balancerItem) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	updated := make(map[string]bool)
+	for _, val := range conf {
+		s.items[val.addr] = val
+		updated[val.addr] = true
+	}
+	return s.reset(updated)
+}
+
+func (s *subsetBalancer) Remove(addr string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.items[addr]; !ok {
+		return errors.New("addr none")
+	}
+
+	delete(s.items, addr)
+	return s.reset(nil)
+}
+
+func (s *subsetBalancer) Hosts() []HostInfo {
+	return s.b.Hosts()
+}
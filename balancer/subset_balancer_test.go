@@ -0,0 +1,118 @@
+package balancer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSubsetConfig(hosts int) balancerConfig {
+	conf := balancerConfig{balancerTyp: RoundRobinType}
+	for i := 0; i < hosts; i++ {
+		conf.SetItem(NewBalancerItem("10.0.0."+strconv.Itoa(i), 1))
+	}
+	return conf
+}
+
+// hosts in a but not in b.
+func subsetDiff(a, b []string) []string {
+	in := make(map[string]bool)
+	for _, addr := range b {
+		in[addr] = true
+	}
+
+	diff := []string{}
+	for _, addr := range a {
+		if !in[addr] {
+			diff = append(diff, addr)
+		}
+	}
+	return diff
+}
+
+func TestSubsetBalancer_Distribution(t *testing.T) {
+	count := map[string]int{}
+	for i := 0; i < 200; i++ {
+		s, err := NewSubsetBalancer(newTestSubsetConfig(100), "client-"+strconv.Itoa(i), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, len(s.Hosts()), 10)
+
+		for _, addr := range s.Subset() {
+			count[addr]++
+		}
+	}
+
+	// every host serves 20 clients, ±1.
+	assert.Equal(t, len(count), 100)
+	for addr, num := range count {
+		assert.True(t, num >= 19 && num <= 21, addr)
+	}
+
+	// the subset is stable.
+	a, err := NewSubsetBalancer(newTestSubsetConfig(100), "client-0", 10)
+	assert.Nil(t, err)
+	b, err := NewSubsetBalancer(newTestSubsetConfig(100), "client-0", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, a.Subset(), b.Subset())
+
+	// the clientId not ending with a number is hashed.
+	assert.Equal(t, getClientIndex("client-12"), uint64(12))
+	assert.Equal(t, getClientIndex("12"), uint64(12))
+	assert.NotEqual(t, getClientIndex("client"), getClientIndex("client-a"))
+}
+
+func TestSubsetBalancer_AddRemove(t *testing.T) {
+	// adding hosts replaces at most one host of the subset for every host added.
+	for _, clientId := range []string{"client-7", "client-0"} {
+		s, err := NewSubsetBalancer(newTestSubsetConfig(50), clientId, 5)
+		assert.Nil(t, err)
+		before := s.Subset()
+
+		for i := 50; i < 60; i++ {
+			assert.Nil(t, s.Add(NewBalancerItem("10.0.0."+strconv.Itoa(i), 1)))
+			after := s.Subset()
+			assert.True(t, len(subsetDiff(before, after)) <= 1, clientId)
+			assert.Equal(t, len(after), 5)
+			before = after
+		}
+	}
+
+	s, err := NewSubsetBalancer(newTestSubsetConfig(60), "client-0", 5)
+	assert.Nil(t, err)
+	before := s.Subset()
+
+	// removing a host of the subset only replaces that host.
+	assert.Nil(t, s.Remove(before[0]))
+	after := s.Subset()
+	assert.Equal(t, subsetDiff(before, after), []string{before[0]})
+	assert.Equal(t, len(s.Hosts()), 5)
+
+	// removing a host out of the first subset of the round changes nothing.
+	before = after
+	for i := 1; i < 60; i++ {
+		addr := "10.0.0." + strconv.Itoa(i)
+		if _, ok := s.items[addr]; ok && len(subsetDiff([]string{addr}, before)) > 0 {
+			assert.Nil(t, s.Remove(addr))
+			break
+		}
+	}
+	assert.Equal(t, s.Subset(), before)
+
+	for i := 0; i < 10; i++ {
+		host, done, err := s.Pick(nil)
+		assert.Nil(t, err)
+		assert.Contains(t, before, host.GetAddr())
+		done(DoneInfo{})
+	}
+}
+
+func TestSubsetBalancer_Small(t *testing.T) {
+	// less hosts than the subset size.
+	s, err := NewSubsetBalancer(newTestSubsetConfig(3), "client-0", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, len(s.Hosts()), 3)
+
+	_, err = NewSubsetBalancer(newTestSubsetConfig(3), "client-0", 0)
+	assert.NotNil(t, err)
+}
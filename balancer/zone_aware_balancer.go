@@ -0,0 +1,332 @@
+package balancer
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EAHITechnology/raptor/utils"
+	"github.com/EAHITechnology/raptor/utils/rand2"
+)
+
+const (
+	// spill over to the other zones when less than this percent of the local wight is available.
+	DEFAULT_ZONE_SPILLOVER_THRESHOLD = 70
+
+	// the available wight of every zone is cached for this long.
+	ZONE_AVAILABLE_REFRESH_MS = 200
+)
+
+/*
+zoneAwareBalancer keeps a balancer of `balancerTyp` for every zone,
+and prefers the hosts in the local zone.
+
+When the available wight of the local zone falls below `spilloverThreshold` percent
+of its total wight, or there is no host in the local zone,
+Pick chooses a zone at random according to the available wight of every zone.
+A host is available when all the checkers set by SetAvailable accept it.
+
+The available wight of every zone is cached for ZONE_AVAILABLE_REFRESH_MS,
+and the checkers are never called while holding the lock,
+so that they can take their own locks, eg: the outlier detection.
+Pick still never picks an unavailable host while the zone has an available one.
+*/
+type zoneAwareBalancer struct {
+	conf               balancerConfig
+	localZone          string
+	spilloverThreshold int64
+
+	zones     map[string]Balancer
+	items     map[string]balancerItem
+	available []func(addr string) bool
+	// zone => total wight
+	totals map[string]int
+	// zone => available wight, refreshed every ZONE_AVAILABLE_REFRESH_MS
+	availables  map[string]int
+	refreshedMs int64
+	// changes of the hosts and the checkers, a refresh started before a change is stale.
+	version int64
+	lock    sync.RWMutex
+	rand    *rand2.Rand
+
+	// only one refresh at the same time, read/write through atomic operation
+	refreshing int32
+}
+
+func NewZoneAwareBalancer(conf balancerConfig, localZone string, spilloverThreshold int64) (*zoneAwareBalancer, error) {
+	if len(conf.balancerConfigs) == 0 {
+		return nil, errors.New("addr nil")
+	}
+
+	// the key ranges are global, they can not be split by zone.
+	if conf.balancerTyp == RangeType {
+		return nil, errors.New("illegal type")
+	}
+
+	if spilloverThreshold <= 0 || spilloverThreshold > 100 {
+		spilloverThreshold = DEFAULT_ZONE_SPILLOVER_THRESHOLD
+	}
+
+	z := &zoneAwareBalancer{
+		conf:               conf,
+		localZone:          localZone,
+		spilloverThreshold: spilloverThreshold,
+		zones:              make(map[string]Balancer),
+		items:              make(map[string]balancerItem),
+		totals:             make(map[string]int),
+		availables:         make(map[string]int),
+		rand:               rand2.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	// the hosts of a zone are built together, so they start up at the same time, eg: slow start.
+	zoneItems := make(map[string][]balancerItem)
+	for _, val := range conf.balancerConfigs {
+		if val.zone == "" {
+			return nil, errors.New("zone nil")
+		}
+		// the addr appears more than once in conf, the first one wins.
+		if _, ok := z.items[val.addr]; ok {
+			continue
+		}
+		z.items[val.addr] = val
+		zoneItems[val.zone] = append(zoneItems[val.zone], val)
+	}
+
+	for zone, items := range zoneItems {
+		zoneConf := conf
+		zoneConf.balancerConfigs = items
+
+		b, err := NewBalancer(zoneConf)
+		if err != nil {
+			return nil, err
+		}
+		z.zones[zone] = b
+	}
+	z.changed()
+	return z, nil
+}
+
+// SetAvailable sets the checkers of the hosts, eg: the health checker and the outlier detection.
+func (z *zoneAwareBalancer) SetAvailable(available ...func(addr string) bool) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	z.available = available
+	z.changed()
+}
+
+// the cached available wights are refreshed by the next Pick, guarded by lock.
+func (z *zoneAwareBalancer) changed() {
+	z.version++
+	z.refreshedMs = 0
+
+	z.totals = make(map[string]int)
+	for _, item := range z.items {
+		z.totals[item.zone] += item.wight
+	}
+
+	// the hosts are available until the first refresh.
+	if len(z.availables) == 0 {
+		for zone, wight := range z.totals {
+			z.availables[zone] = wight
+		}
+	}
+}
+
+// refresh the available wight of every zone when it is stale, the checkers are called out of the lock.
+func (z *zoneAwareBalancer) refresh(nowMs int64) {
+	z.lock.RLock()
+	if nowMs-z.refreshedMs < ZONE_AVAILABLE_REFRESH_MS {
+		z.lock.RUnlock()
+		return
+	}
+	z.lock.RUnlock()
+
+	// the others pick with the stale wights.
+	if !atomic.CompareAndSwapInt32(&z.refreshing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&z.refreshing, 0)
+
+	z.lock.RLock()
+	version, available := z.version, allAvailable(z.available...)
+	items := make([]balancerItem, 0, len(z.items))
+	for _, item := range z.items {
+		items = append(items, item)
+	}
+	z.lock.RUnlock()
+
+	availables := make(map[string]int)
+	for _, item := range items {
+		if available(item.addr) {
+			availables[item.zone] += item.wight
+		}
+	}
+
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	z.availables = availables
+	if z.version == version {
+		z.refreshedMs = nowMs
+	}
+}
+
+// guarded by lock.
+func (z *zoneAwareBalancer) pickZone() string {
+	totals, availables := z.totals, z.availables
+
+	if total := int64(totals[z.localZone]); total > 0 && int64(availables[z.localZone])*100 >= total*z.spilloverThreshold {
+		return z.localZone
+	}
+
+	// all the hosts are unavailable, fall back to the total wight.
+	wights, sum := availables, 0
+	for _, wight := range availables {
+		sum += wight
+	}
+	if sum == 0 {
+		wights = totals
+		for _, wight := range totals {
+			sum += wight
+		}
+	}
+
+	if sum <= 0 {
+		return z.localZone
+	}
+
+	r := z.rand.Intn(sum)
+	for zone, wight := range wights {
+		if r < wight {
+			return zone
+		}
+		r -= wight
+	}
+	return z.localZone
+}
+
+func (z *zoneAwareBalancer) Pick(key []byte) (HostInfo, DoneFunc, error) {
+	return z.pick(key, nil, true)
+}
+
+func (z *zoneAwareBalancer) pickFrom(key []byte, available func(addr string) bool) (HostInfo, DoneFunc, error) {
+	return z.pick(key, available, false)
+}
+
+/*
+pick from the chosen zone, and move on to the other zones when the chosen one has no available host,
+as the cached available wights may be stale.
+fallback picks from the chosen zone when all the hosts are unavailable.
+*/
+func (z *zoneAwareBalancer) pick(key []byte, available func(addr string) bool, fallback bool) (HostInfo, DoneFunc, error) {
+	z.refresh(utils.GetNowMs())
+
+	z.lock.RLock()
+	if len(z.zones) == 0 {
+		z.lock.RUnlock()
+		return nil, nil, errors.New("list is null")
+	}
+
+	zone := z.pickZone()
+	zones := []Balancer{}
+	if b, ok := z.zones[zone]; ok {
+		zones = append(zones, b)
+	}
+	for name, b := range z.zones {
+		if name != zone {
+			zones = append(zones, b)
+		}
+	}
+	available = allAvailable(append(append([]func(addr string) bool{}, z.available...), available)...)
+	z.lock.RUnlock()
+
+	for _, b := range zones {
+		host, done, err := pickFrom(b, key, available)
+		if err != errNoAvailableHost {
+			return host, done, err
+		}
+	}
+
+	if !fallback {
+		return nil, nil, errNoAvailableHost
+	}
+	return zones[0].Pick(key)
+}
+
+func (z *zoneAwareBalancer) Add(conf ...balancerItem) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	defer z.changed()
+
+	for _, val := range conf {
+		if val.zone == "" {
+			return errors.New("zone nil")
+		}
+
+		// the addr moves to another zone.
+		if old, ok := z.items[val.addr]; ok && old.zone != val.zone {
+			if err := z.remove(val.addr); err != nil {
+				return err
+			}
+		}
+
+		b, ok := z.zones[val.zone]
+		if !ok {
+			zoneConf := z.conf
+			zoneConf.balancerConfigs = []balancerItem{val}
+
+			var err error
+			if b, err = NewBalancer(zoneConf); err != nil {
+				return err
+			}
+			z.zones[val.zone] = b
+		} else if err := b.Add(val); err != nil {
+			return err
+		}
+
+		z.items[val.addr] = val
+	}
+	return nil
+}
+
+// guarded by lock.
+func (z *zoneAwareBalancer) remove(addr string) error {
+	item, ok := z.items[addr]
+	if !ok {
+		return errors.New("addr none")
+	}
+
+	b := z.zones[item.zone]
+	if err := b.Remove(addr); err != nil {
+		return err
+	}
+	delete(z.items, addr)
+
+	if len(b.Hosts()) == 0 {
+		delete(z.zones, item.zone)
+	}
+	return nil
+}
+
+func (z *zoneAwareBalancer) Remove(addr string) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	defer z.changed()
+
+	return z.remove(addr)
+}
+
+func (z *zoneAwareBalancer) Hosts() []HostInfo {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+
+	hosts := []HostInfo{}
+	for _, b := range z.zones {
+		hosts = append(hosts, b.Hosts()...)
+	}
+	return hosts
+}
@@ -0,0 +1,191 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestZoneAwareBalancer(t *testing.T) *zoneAwareBalancer {
+	z, err := NewZoneAwareBalancer(balancerConfig{
+		balancerTyp: RoundRobinType,
+		balancerConfigs: []balancerItem{
+			NewZoneBalancerItem("a1", 1, "zone-a"),
+			NewZoneBalancerItem("a2", 1, "zone-a"),
+			NewZoneBalancerItem("a3", 1, "zone-a"),
+			NewZoneBalancerItem("b1", 1, "zone-b"),
+			NewZoneBalancerItem("b2", 1, "zone-b"),
+		},
+	}, "zone-a", 60)
+	assert.Nil(t, err)
+	return z
+}
+
+func pickZones(t *testing.T, z *zoneAwareBalancer, num int) map[string]int {
+	count := map[string]int{}
+	for i := 0; i < num; i++ {
+		host, done, err := z.Pick(nil)
+		assert.Nil(t, err)
+		count[z.items[host.GetAddr()].zone]++
+		done(DoneInfo{})
+	}
+	return count
+}
+
+func TestZoneAwareBalancer_Local(t *testing.T) {
+	z := newTestZoneAwareBalancer(t)
+	assert.Equal(t, len(z.Hosts()), 5)
+
+	count := pickZones(t, z, 30)
+	assert.Equal(t, count["zone-a"], 30)
+
+	// 2 of the 3 local hosts are available, above the threshold.
+	z.SetAvailable(func(addr string) bool {
+		return addr != "a1"
+	})
+	count = pickZones(t, z, 30)
+	assert.Equal(t, count["zone-a"], 30)
+}
+
+func TestZoneAwareBalancer_Spillover(t *testing.T) {
+	z := newTestZoneAwareBalancer(t)
+
+	// 1 of the 3 local hosts is available, below the threshold.
+	z.SetAvailable(func(addr string) bool {
+		return addr != "a1" && addr != "a2"
+	})
+	count := pickZones(t, z, 3000)
+	t.Log(count)
+	assert.True(t, count["zone-a"] > 700 && count["zone-a"] < 1300)
+	assert.True(t, count["zone-b"] > 1700)
+
+	// no host in the local zone.
+	for _, addr := range []string{"a1", "a2", "a3"} {
+		assert.Nil(t, z.Remove(addr))
+	}
+	count = pickZones(t, z, 30)
+	assert.Equal(t, count["zone-b"], 30)
+}
+
+func TestZoneAwareBalancer_AddRemove(t *testing.T) {
+	z := newTestZoneAwareBalancer(t)
+
+	// move a host to another zone.
+	assert.Nil(t, z.Add(NewZoneBalancerItem("a3", 1, "zone-c")))
+	assert.Equal(t, len(z.Hosts()), 5)
+	assert.Equal(t, len(z.zones), 3)
+
+	assert.Nil(t, z.Remove("a3"))
+	assert.Equal(t, len(z.zones), 2)
+	assert.NotNil(t, z.Remove("a3"))
+
+	// a host without zone.
+	assert.NotNil(t, z.Add(NewBalancerItem("c1", 1)))
+	assert.Equal(t, len(z.Hosts()), 4)
+
+	_, err := NewZoneAwareBalancer(balancerConfig{
+		balancerTyp:     RangeType,
+		balancerConfigs: []balancerItem{NewZoneBalancerItem("a1", 1, "zone-a")},
+	}, "zone-a", 60)
+	assert.NotNil(t, err)
+}
+
+func TestZoneAwareBalancer_SlowStart(t *testing.T) {
+	for _, typ := range []BalancerTyp{RandomType, P2cType, RoundRobinType} {
+		conf := balancerConfig{
+			balancerTyp: typ,
+			balancerConfigs: []balancerItem{
+				NewZoneBalancerItem("a1", 1, "zone-a"),
+				NewZoneBalancerItem("a2", 1, "zone-a"),
+				NewZoneBalancerItem("a3", 1, "zone-a"),
+			},
+		}
+		conf.SetSlowStart(10000, 1)
+		z, err := NewZoneAwareBalancer(conf, "zone-a", 60)
+		assert.Nil(t, err)
+
+		// the initial hosts start up together, none of them is warming up.
+		count := map[string]int{}
+		for i := 0; i < 3000; i++ {
+			host, done, err := z.Pick(nil)
+			assert.Nil(t, err)
+			count[host.GetAddr()]++
+			done(DoneInfo{})
+		}
+		t.Log(typ, count)
+		for _, addr := range []string{"a1", "a2", "a3"} {
+			assert.True(t, count[addr] > 800, addr)
+		}
+	}
+}
+
+func TestZoneAwareBalancer_OutlierConcurrent(t *testing.T) {
+	z := newTestZoneAwareBalancer(t)
+	o, err := NewOutlierDetectionBalancer(z, NewOutlierDetectionConfig().
+		SetConsecutiveFailures(1).
+		SetMaxEjectionPercent(50).
+		SetBaseEjectionMs(10))
+	assert.Nil(t, err)
+	z.SetAvailable(o.IsAvailable)
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				host, done, err := o.Pick(nil)
+				if err != nil {
+					continue
+				}
+				// every failure reports to the outlier detection, which counts the hosts.
+				_ = host
+				done(DoneInfo{Err: errors.New("just_error")})
+			}
+		}()
+	}
+
+	// the writer takes the lock of the zone aware balancer.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			addr := fmt.Sprintf("c%d", i%10)
+			o.Add(NewZoneBalancerItem(addr, 1, "zone-c"))
+			o.Remove(addr)
+			z.SetAvailable(o.IsAvailable)
+		}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock")
+	}
+}
@@ -0,0 +1,17 @@
+package breaker
+
+import "context"
+
+/*
+Breaker 保护对下游的调用，eg: CircuitBreaker, SreBreaker。
+
+Do 放行时执行 run，run 的错误与被拒绝时的 ErrCircuitBreak 交由 fallback 处理。
+*/
+type Breaker interface {
+	Do(ctx context.Context, run runFunc, fallback fallbackFunc) error
+}
+
+var (
+	_ Breaker = (*CircuitBreaker)(nil)
+	_ Breaker = (*SreBreaker)(nil)
+)
@@ -3,9 +3,19 @@ package breaker
 import (
 	"context"
 	"errors"
+	"math"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/EAHITechnology/raptor/utils"
+	"github.com/EAHITechnology/raptor/utils/rand2"
+)
+
+const (
+	// 未配置滑动窗口时的默认值, 10 个窗口，各 1s，共 10s。
+	DefaultSize           = int64(10)
+	DefaultCellIntervalMs = int64(1000)
 )
 
 const (
@@ -23,18 +33,48 @@ type CircuitBreakerConfig struct {
 	failureNum           int64
 	size                 int64
 	cellIntervalMs       int64
+	// half open 状态下放行的探测请求数, 默认 1
+	halfOpenProbes int64
+	// 探测请求成功率(百分比)达到后关闭熔断器, 默认 100
+	halfOpenSuccessRatio int64
+	// 耗时超过此值的请求记为慢调用, 0 表示不统计慢调用
+	slowCallDurationMs    int64
+	slowCallRateThreshold int64 // 慢调用率(百分比)
+	// 探测失败后 open 时长乘以此值, 大于 1 时生效, 熔断器关闭后恢复为 OpenStatusDurationMs
+	openBackoffMultiplier float64
+	// open 时长的上限, 0 表示不设上限
+	maxOpenStatusDurationMs int64
+	// open 时长随机浮动的比例, 取值 [0, 1)
+	openBackoffJitter float64
+	// 决定 run 的错误是否计为失败, 默认为 DefaultErrorClassifier
+	errorClassifier ErrorClassifier
 }
 
 type CircuitBreaker struct {
-	mu *sync.Mutex          // guard sw, config, status, halfOpenStartMs, halfOpenProbeSent
+	mu *sync.Mutex          // guard sw, config, status, halfOpenStartMs, halfOpenProbeSent, halfOpen*
 	sw *utils.SlidingWindow // guarded by mu
 	// 熔断器配置，仅通过 ChangeConfig() 方法修改
 	config CircuitBreakerConfig // guarded by mu
 	// 熔断器状态，随运行状态而随时变化
-	status            int32 // guarded by mu
-	openStartMs       int64 // guarded by mu
-	halfOpenStartMs   int64 // guarded by mu
-	halfOpenProbeSent bool  // guarded by mu
+	status          int32 // guarded by mu
+	openStartMs     int64 // guarded by mu
+	halfOpenStartMs int64 // guarded by mu
+	// 所有探测请求均已放行
+	halfOpenProbeSent  bool  // guarded by mu
+	halfOpenProbesSent int64 // guarded by mu
+	halfOpenSuccesses  int64 // guarded by mu
+	halfOpenFailures   int64 // guarded by mu
+	// 连续探测失败的次数
+	openBackoffs int64 // guarded by mu
+	// 本次 open 时长的随机浮动, 取值 [-openBackoffJitter, openBackoffJitter]
+	openJitter float64 // guarded by mu
+	rand       *rand2.Rand
+
+	listeners []StateChangeListener // guarded by mu
+	// closed 状态下每次计入统计的请求, 供 DistributedBreaker 汇总计数
+	onHit func(nowMs int64, isFailureHit bool) // guarded by mu
+	// 尚未通知 listeners 的状态迁移
+	events []StateChangeEvent // guarded by mu
 }
 
 func NewCircuitBreakerConfig() *CircuitBreakerConfig {
@@ -76,62 +116,198 @@ func (c *CircuitBreakerConfig) SetCellIntervalMs(cellIntervalMs int64) *CircuitB
 	return c
 }
 
+func (c *CircuitBreakerConfig) SetHalfOpenProbes(halfOpenProbes int64) *CircuitBreakerConfig {
+	c.halfOpenProbes = halfOpenProbes
+	return c
+}
+
+func (c *CircuitBreakerConfig) SetHalfOpenSuccessRatio(halfOpenSuccessRatio int64) *CircuitBreakerConfig {
+	c.halfOpenSuccessRatio = halfOpenSuccessRatio
+	return c
+}
+
+func (c *CircuitBreakerConfig) SetSlowCallDurationMs(slowCallDurationMs int64) *CircuitBreakerConfig {
+	c.slowCallDurationMs = slowCallDurationMs
+	return c
+}
+
+func (c *CircuitBreakerConfig) SetSlowCallRateThreshold(slowCallRateThreshold int64) *CircuitBreakerConfig {
+	c.slowCallRateThreshold = slowCallRateThreshold
+	return c
+}
+
+func (c *CircuitBreakerConfig) SetOpenBackoffMultiplier(openBackoffMultiplier float64) *CircuitBreakerConfig {
+	c.openBackoffMultiplier = openBackoffMultiplier
+	return c
+}
+
+func (c *CircuitBreakerConfig) SetMaxOpenStatusDurationMs(maxOpenStatusDurationMs int64) *CircuitBreakerConfig {
+	c.maxOpenStatusDurationMs = maxOpenStatusDurationMs
+	return c
+}
+
+func (c *CircuitBreakerConfig) SetOpenBackoffJitter(openBackoffJitter float64) *CircuitBreakerConfig {
+	c.openBackoffJitter = openBackoffJitter
+	return c
+}
+
+func (c *CircuitBreakerConfig) SetErrorClassifier(errorClassifier ErrorClassifier) *CircuitBreakerConfig {
+	c.errorClassifier = errorClassifier
+	return c
+}
+
+// 需要放行的探测请求数
+func (c *CircuitBreakerConfig) getHalfOpenProbes() int64 {
+	if c.halfOpenProbes <= 0 {
+		return 1
+	}
+	return c.halfOpenProbes
+}
+
+// 关闭熔断器所需的探测成功数，至少为 1
+func (c *CircuitBreakerConfig) getHalfOpenSuccesses() int64 {
+	ratio := c.halfOpenSuccessRatio
+	if ratio <= 0 || ratio > 100 {
+		ratio = 100
+	}
+
+	probes := c.getHalfOpenProbes()
+	successes := (probes*ratio + 99) / 100
+	if successes < 1 {
+		successes = 1
+	}
+	return successes
+}
+
 func NewCircuitBreaker(config *CircuitBreakerConfig) *CircuitBreaker {
 	status := CircuitBreakerStatusClosed // 默认为 closed 状态
 	if config.forceOpen {
 		status = CircuitBreakerStatusForceOpen
 	}
+
+	size, cellIntervalMs := config.size, config.cellIntervalMs
+	if size <= 0 || cellIntervalMs <= 0 {
+		size, cellIntervalMs = DefaultSize, DefaultCellIntervalMs
+	}
+
 	return &CircuitBreaker{
 		mu: &sync.Mutex{},
-		sw: utils.NewSlidingWindow(config.size, config.cellIntervalMs), // 10 个窗口，各 1s，共 10s。
+		sw: utils.NewSlidingWindow(size, cellIntervalMs),
 		config: CircuitBreakerConfig{
-			minQPS:               config.minQPS,
-			failureRateThreshold: config.failureRateThreshold,
-			failureNum:           config.failureNum,
-			OpenStatusDurationMs: config.OpenStatusDurationMs,
-			forceOpen:            config.forceOpen,
-			size:                 config.size,
-			cellIntervalMs:       config.cellIntervalMs,
+			minQPS:                  config.minQPS,
+			failureRateThreshold:    config.failureRateThreshold,
+			failureNum:              config.failureNum,
+			OpenStatusDurationMs:    config.OpenStatusDurationMs,
+			forceOpen:               config.forceOpen,
+			size:                    size,
+			cellIntervalMs:          cellIntervalMs,
+			halfOpenProbes:          config.halfOpenProbes,
+			halfOpenSuccessRatio:    config.halfOpenSuccessRatio,
+			slowCallDurationMs:      config.slowCallDurationMs,
+			slowCallRateThreshold:   config.slowCallRateThreshold,
+			openBackoffMultiplier:   config.openBackoffMultiplier,
+			maxOpenStatusDurationMs: config.maxOpenStatusDurationMs,
+			openBackoffJitter:       config.openBackoffJitter,
+			errorClassifier:         config.errorClassifier,
 		},
 		status:            status,
 		openStartMs:       0,
 		halfOpenStartMs:   0,
 		halfOpenProbeSent: false,
+		rand:              rand2.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// AddListener 注册状态迁移的监听者
+func (cb *CircuitBreaker) AddListener(listeners ...StateChangeListener) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.listeners = append(cb.listeners, listeners...)
+}
+
+// 迁移到 status 并记录事件, guarded by mu
+func (cb *CircuitBreaker) setStatus(status int32, nowMs int64) {
+	if cb.status == status {
+		return
+	}
+
+	if len(cb.listeners) > 0 {
+		hitsStat := cb.sw.GetHits(nowMs, TotalHit, FailureHit, SlowHit)
+		cb.events = append(cb.events, StateChangeEvent{
+			From:        cb.status,
+			To:          status,
+			TimestampMs: nowMs,
+			TotalHits:   hitsStat[TotalHit],
+			FailureHits: hitsStat[FailureHit],
+			SlowHits:    hitsStat[SlowHit],
+		})
+	}
+	cb.status = status
+
+	// 熔断器关闭后退避重新开始
+	if status == CircuitBreakerStatusClosed || status == CircuitBreakerStatusForceOpen {
+		cb.openBackoffs = 0
+	}
+}
+
+// 在释放锁之后通知 listeners
+func (cb *CircuitBreaker) notify() {
+	cb.mu.Lock()
+	events, listeners := cb.events, cb.listeners
+	cb.events = nil
+	cb.mu.Unlock()
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
 	}
 }
 
 func (cb *CircuitBreaker) ChangeConfig(config *CircuitBreakerConfig) {
+	defer cb.notify()
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
+	nowMs := utils.GetNowMs()
+
 	oldConfig := cb.config
 	cb.config = CircuitBreakerConfig{
-		minQPS:               config.minQPS,
-		failureRateThreshold: config.failureRateThreshold,
-		OpenStatusDurationMs: config.OpenStatusDurationMs,
-		failureNum:           config.failureNum,
-		forceOpen:            config.forceOpen,
-		size:                 config.size,
-		cellIntervalMs:       config.cellIntervalMs,
+		minQPS:                  config.minQPS,
+		failureRateThreshold:    config.failureRateThreshold,
+		OpenStatusDurationMs:    config.OpenStatusDurationMs,
+		failureNum:              config.failureNum,
+		forceOpen:               config.forceOpen,
+		size:                    config.size,
+		cellIntervalMs:          config.cellIntervalMs,
+		halfOpenProbes:          config.halfOpenProbes,
+		halfOpenSuccessRatio:    config.halfOpenSuccessRatio,
+		slowCallDurationMs:      config.slowCallDurationMs,
+		slowCallRateThreshold:   config.slowCallRateThreshold,
+		openBackoffMultiplier:   config.openBackoffMultiplier,
+		maxOpenStatusDurationMs: config.maxOpenStatusDurationMs,
+		openBackoffJitter:       config.openBackoffJitter,
+		errorClassifier:         config.errorClassifier,
 	}
 
 	if config.forceOpen {
-		cb.status = CircuitBreakerStatusForceOpen
+		cb.setStatus(CircuitBreakerStatusForceOpen, nowMs)
 		cb.openStartMs = 0
 		cb.halfOpenStartMs = 0
-		cb.halfOpenProbeSent = false
+		cb.resetHalfOpen()
 		for _, cell := range cb.sw.Cells {
 			cell.Reset()
 		}
 	} else {
 		if cb.status == CircuitBreakerStatusForceOpen {
 			// 当 forceOpen 从打开变为关闭时，回到 closed 状态。
-			cb.status = CircuitBreakerStatusClosed
+			cb.setStatus(CircuitBreakerStatusClosed, nowMs)
 		}
 
 		if cb.status == CircuitBreakerStatusOpen && config.minQPS > oldConfig.minQPS {
 			// 如果提高了 minQPS，而当前已为熔断状态，则置为 closed 状态。
-			cb.status = CircuitBreakerStatusClosed
+			cb.setStatus(CircuitBreakerStatusClosed, nowMs)
 		}
 	}
 }
@@ -140,14 +316,52 @@ func (cb *CircuitBreaker) GetHalfOpenProbeSent() bool {
 	return cb.halfOpenProbeSent
 }
 
+// true 表示所有探测请求均已放行，false 表示重新开始探测。
 func (cb *CircuitBreaker) SetHalfOpenProbeSent(halfOpenProbeSent bool) {
-	cb.halfOpenProbeSent = halfOpenProbeSent
+	if !halfOpenProbeSent {
+		cb.resetHalfOpen()
+		return
+	}
+	cb.halfOpenProbeSent = true
+	cb.halfOpenProbesSent = cb.config.getHalfOpenProbes()
+}
+
+// 重置探测状态, guarded by mu
+func (cb *CircuitBreaker) resetHalfOpen() {
+	cb.halfOpenProbeSent = false
+	cb.halfOpenProbesSent = 0
+	cb.halfOpenSuccesses = 0
+	cb.halfOpenFailures = 0
+}
+
+// 归还被忽略的探测请求, 以便重新探测, guarded by mu
+func (cb *CircuitBreaker) releaseProbe() {
+	if cb.status != CircuitBreakerStatusHalfOpen || cb.halfOpenProbesSent == 0 {
+		return
+	}
+
+	cb.halfOpenProbesSent--
+	cb.halfOpenProbeSent = false
+}
+
+// 放行一个探测请求, guarded by mu
+func (cb *CircuitBreaker) acquireProbe() bool {
+	if cb.halfOpenProbeSent {
+		return false
+	}
+
+	cb.halfOpenProbesSent++
+	if cb.halfOpenProbesSent >= cb.config.getHalfOpenProbes() {
+		cb.halfOpenProbeSent = true
+	}
+	return true
 }
 
 func (cb *CircuitBreaker) Status() int32 {
 	// 从 closed -> open 是根据错误率的变化来触发的(Hit 方法)
 	// 从 open -> half_open 是根据时间来触发(此方法)。
 	// 从 half_open -> open, 和 half_open -> close 是根据 probe 来触发的(也是通过 Hit 方法)
+	defer cb.notify()
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
@@ -157,10 +371,11 @@ func (cb *CircuitBreaker) Status() int32 {
 
 	status := cb.status
 	nowMs := utils.GetNowMs()
-	if status == CircuitBreakerStatusOpen && nowMs-cb.openStartMs > cb.config.OpenStatusDurationMs {
-		cb.status = CircuitBreakerStatusHalfOpen
-		cb.halfOpenStartMs = cb.openStartMs + cb.config.OpenStatusDurationMs
-		cb.halfOpenProbeSent = false
+	openDurationMs := cb.getOpenDurationMs()
+	if status == CircuitBreakerStatusOpen && nowMs-cb.openStartMs > openDurationMs {
+		cb.setStatus(CircuitBreakerStatusHalfOpen, nowMs)
+		cb.halfOpenStartMs = cb.openStartMs + openDurationMs
+		cb.resetHalfOpen()
 		// 重置其他状态字段
 		cb.openStartMs = 0
 		status = CircuitBreakerStatusHalfOpen
@@ -183,63 +398,100 @@ func (cb *CircuitBreaker) Do(ctx context.Context, run runFunc, fallback fallback
 	status := cb.Status()
 	switch status {
 	case CircuitBreakerStatusClosed:
-		failed := false
-		err := run(ctx)
-		if err != nil {
-			failed = true
-		}
-		cb.Hit(utils.GetNowMs(), false, failed)
-		if failed {
-			return fallback(ctx, err)
-		}
+		return cb.run(ctx, false, run, fallback)
 	case CircuitBreakerStatusOpen:
 		return fallback(ctx, ErrCircuitBreak)
 	case CircuitBreakerStatusHalfOpen:
 		cb.mu.Lock()
-		halfOpenProbeSent := cb.halfOpenProbeSent
-		if !cb.halfOpenProbeSent {
-			cb.halfOpenProbeSent = true
-		}
+		acquired := cb.acquireProbe()
 		cb.mu.Unlock()
 
-		if halfOpenProbeSent {
+		if !acquired {
 			return fallback(ctx, ErrCircuitBreak)
 		}
 
 		// send probe
-		failed := false
-		err := run(ctx)
-		if err != nil {
-			failed = true
-		}
-		cb.Hit(utils.GetNowMs(), true, failed)
-		if failed {
-			return fallback(ctx, err)
-		}
+		return cb.run(ctx, true, run, fallback)
 	case CircuitBreakerStatusForceOpen:
 		return fallback(ctx, ErrCircuitBreak)
 	}
 	return nil
 }
 
+// 执行 run，按 errorClassifier 的分类计入统计，只有失败交由 fallback 处理。
+func (cb *CircuitBreaker) run(ctx context.Context, isProbe bool, run runFunc, fallback fallbackFunc) error {
+	startMs := utils.GetNowMs()
+	err := run(ctx)
+	nowMs := utils.GetNowMs()
+
+	cb.mu.Lock()
+	classifier := cb.config.errorClassifier
+	cb.mu.Unlock()
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+
+	switch classifier(ctx, err) {
+	case ErrorClassIgnore:
+		if isProbe {
+			cb.mu.Lock()
+			cb.releaseProbe()
+			cb.mu.Unlock()
+		}
+		return err
+	case ErrorClassSuccess:
+		cb.HitWithDuration(nowMs, isProbe, false, nowMs-startMs)
+		return err
+	default:
+		cb.HitWithDuration(nowMs, isProbe, true, nowMs-startMs)
+		return fallback(ctx, err)
+	}
+}
+
 const (
 	TotalHit   = "total"
 	FailureHit = "failure"
+	SlowHit    = "slow"
 )
 
 // 根据请求的成败，驱动 CircuitBreaker 状态迁移。
 func (cb *CircuitBreaker) Hit(nowMs int64, isProbe bool, isFailureHit bool) {
+	cb.HitWithDuration(nowMs, isProbe, isFailureHit, 0)
+}
+
+// 根据请求的成败与耗时，驱动 CircuitBreaker 状态迁移。
+func (cb *CircuitBreaker) HitWithDuration(nowMs int64, isProbe bool, isFailureHit bool, durationMs int64) {
 	status := cb.Status()
+	defer cb.notify()
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
+	isSlowHit := cb.config.slowCallDurationMs > 0 && durationMs > cb.config.slowCallDurationMs
+
 	switch status {
 	case CircuitBreakerStatusClosed:
 		metrics := []string{TotalHit}
 		if isFailureHit {
-			metrics = []string{TotalHit, FailureHit}
+			metrics = append(metrics, FailureHit)
+		}
+		if isSlowHit {
+			metrics = append(metrics, SlowHit)
 		}
 		cb.sw.Hit(nowMs, metrics...)
+		if cb.onHit != nil {
+			cb.onHit(nowMs, isFailureHit)
+		}
+
+		if isSlowHit && cb.config.slowCallRateThreshold > 0 {
+			hitsStat := cb.sw.GetHits(nowMs, TotalHit, SlowHit)
+			slowHits := hitsStat[SlowHit]
+			totalHits := hitsStat[TotalHit]
+			slowRate := int64(float64(slowHits) * 100 / float64(totalHits))
+			if slowRate > cb.config.slowCallRateThreshold && (totalHits*1000/(cb.sw.Size*cb.sw.CellIntervalMs) > cb.config.minQPS) {
+				cb.toOpen(nowMs)
+				return
+			}
+		}
 
 		if isFailureHit {
 			statusCouldChange := false
@@ -262,11 +514,7 @@ func (cb *CircuitBreaker) Hit(nowMs int64, isProbe bool, isFailureHit bool) {
 			}
 
 			if statusCouldChange {
-				cb.status = CircuitBreakerStatusOpen
-				cb.openStartMs = nowMs
-				// reset other fields
-				cb.halfOpenStartMs = 0
-				cb.halfOpenProbeSent = false
+				cb.toOpen(nowMs)
 			}
 		}
 	case CircuitBreakerStatusOpen:
@@ -278,16 +526,21 @@ func (cb *CircuitBreaker) Hit(nowMs int64, isProbe bool, isFailureHit bool) {
 			return
 		}
 
-		if isFailureHit {
-			cb.status = CircuitBreakerStatusOpen
-			cb.openStartMs = nowMs
-			// reset other fields
-			cb.halfOpenStartMs = 0
-			cb.halfOpenProbeSent = false
+		// 慢调用的探测请求也记为失败
+		if isFailureHit || isSlowHit {
+			cb.halfOpenFailures++
 		} else {
-			cb.status = CircuitBreakerStatusClosed
+			cb.halfOpenSuccesses++
+		}
+
+		successes := cb.config.getHalfOpenSuccesses()
+		if cb.halfOpenFailures > cb.config.getHalfOpenProbes()-successes {
+			// 成功率已无法达到要求
+			cb.toOpen(nowMs)
+		} else if cb.halfOpenSuccesses >= successes {
+			cb.setStatus(CircuitBreakerStatusClosed, nowMs)
 			cb.halfOpenStartMs = nowMs
-			cb.halfOpenProbeSent = false
+			cb.resetHalfOpen()
 			// reset other fields
 			cb.openStartMs = nowMs
 		}
@@ -295,3 +548,85 @@ func (cb *CircuitBreaker) Hit(nowMs int64, isProbe bool, isFailureHit bool) {
 		// 出现这种情况，因为发起调用时状态为 closed，但等到调用完成时，circuit breaker 已被重置为 force open 了。
 	}
 }
+
+// 进入 open 状态, guarded by mu
+func (cb *CircuitBreaker) toOpen(nowMs int64) {
+	// 探测失败时退避, 由 closed 进入 open 时 openBackoffs 为 0
+	if cb.status == CircuitBreakerStatusHalfOpen {
+		cb.openBackoffs++
+	}
+	cb.openJitter = 0
+	if cb.config.openBackoffJitter > 0 {
+		cb.openJitter = (cb.rand.Float64()*2 - 1) * cb.config.openBackoffJitter
+	}
+
+	cb.setStatus(CircuitBreakerStatusOpen, nowMs)
+	cb.openStartMs = nowMs
+	// reset other fields
+	cb.halfOpenStartMs = 0
+	cb.resetHalfOpen()
+}
+
+/*
+本次 open 的时长, guarded by mu
+
+OpenStatusDurationMs * openBackoffMultiplier^openBackoffs，随机浮动 openJitter 后不超过 maxOpenStatusDurationMs。
+*/
+func (cb *CircuitBreaker) getOpenDurationMs() int64 {
+	if cb.config.openBackoffMultiplier <= 1 || cb.openBackoffs == 0 {
+		return cb.config.OpenStatusDurationMs
+	}
+
+	durationMs := float64(cb.config.OpenStatusDurationMs) * math.Pow(cb.config.openBackoffMultiplier, float64(cb.openBackoffs))
+	durationMs *= 1 + cb.openJitter
+	if cb.config.maxOpenStatusDurationMs > 0 && durationMs > float64(cb.config.maxOpenStatusDurationMs) {
+		return cb.config.maxOpenStatusDurationMs
+	}
+	if durationMs > math.MaxInt64/2 {
+		return math.MaxInt64 / 2
+	}
+	return int64(durationMs)
+}
+
+// open 状态的截止时间
+func (cb *CircuitBreaker) openUntilMs() (int64, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.status != CircuitBreakerStatusOpen {
+		return 0, false
+	}
+	return cb.openStartMs + cb.getOpenDurationMs(), true
+}
+
+/*
+由其他实例的状态触发，从 closed 进入 open。
+untilMs 大于 0 时，open 持续到 untilMs，否则持续本地的 open 时长。
+*/
+func (cb *CircuitBreaker) tripOpen(nowMs int64, untilMs int64) {
+	defer cb.notify()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.config.forceOpen || cb.status != CircuitBreakerStatusClosed {
+		return
+	}
+
+	cb.toOpen(nowMs)
+	if untilMs > 0 {
+		cb.openStartMs = untilMs - cb.getOpenDurationMs()
+	}
+}
+
+// 按错误率判断 total 个请求中的 failure 个失败是否应当熔断
+func (cb *CircuitBreaker) shouldOpen(total int64, failure int64) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if total == 0 {
+		return false
+	}
+
+	failureRate := int64(float64(failure) * 100 / float64(total))
+	return failureRate > cb.config.failureRateThreshold && (total*1000/(cb.sw.Size*cb.sw.CellIntervalMs) > cb.config.minQPS)
+}
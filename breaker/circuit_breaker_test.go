@@ -3,7 +3,9 @@ package breaker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/EAHITechnology/raptor/utils"
 	"github.com/stretchr/testify/assert"
@@ -137,3 +139,293 @@ func TestCircuitBreaker_ChangeConfig_WithoutForceOpen(t *testing.T) {
 	assert.Equal(t, cb.status, CircuitBreakerStatusClosed)
 	assert.Equal(t, cb.Status(), CircuitBreakerStatusClosed)
 }
+
+// 构造一个处于 half open 状态的熔断器
+func newHalfOpenCircuitBreaker(config *CircuitBreakerConfig) *CircuitBreaker {
+	cb := NewCircuitBreaker(config.
+		SetMinQPS(0).
+		SetFailureRateThreshold(10).
+		SetOpenStatusDurationMs(10))
+	cb.status = CircuitBreakerStatusOpen
+	cb.openStartMs = utils.GetNowMs() - 20
+	return cb
+}
+
+func TestCircuitBreaker_HalfOpenProbes(t *testing.T) {
+	ctx := context.Background()
+	cb := newHalfOpenCircuitBreaker(NewCircuitBreakerConfig().
+		SetHalfOpenProbes(4).
+		SetHalfOpenSuccessRatio(75))
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusHalfOpen)
+
+	// 4 个探测请求中有 1 个失败，成功率 75%，关闭熔断器
+	for i := 0; i < 4; i++ {
+		cb.Do(ctx, func(ctx context.Context) error {
+			if i == 0 {
+				return errors.New("just_error")
+			}
+			return nil
+		}, nil)
+		if i < 3 {
+			assert.Equal(t, cb.Status(), CircuitBreakerStatusHalfOpen)
+		}
+	}
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusClosed)
+
+	// 2 个探测请求失败，成功率无法达到 75%，重新打开熔断器
+	cb = newHalfOpenCircuitBreaker(NewCircuitBreakerConfig().
+		SetHalfOpenProbes(4).
+		SetHalfOpenSuccessRatio(75))
+	for i := 0; i < 2; i++ {
+		cb.Do(ctx, func(ctx context.Context) error {
+			return errors.New("just_error")
+		}, nil)
+	}
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusOpen)
+}
+
+func TestCircuitBreaker_HalfOpenProbesLimit(t *testing.T) {
+	cb := newHalfOpenCircuitBreaker(NewCircuitBreakerConfig().SetHalfOpenProbes(3))
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusHalfOpen)
+
+	// 探测请求未完成时，最多放行 3 个
+	runCount, breakCount := 0, 0
+	for i := 0; i < 3; i++ {
+		cb.mu.Lock()
+		if cb.acquireProbe() {
+			runCount++
+		}
+		cb.mu.Unlock()
+	}
+	err := cb.Do(context.Background(), func(ctx context.Context) error {
+		runCount++
+		return nil
+	}, func(ctx context.Context, err error) error {
+		breakCount++
+		return err
+	})
+	assert.Equal(t, err, ErrCircuitBreak)
+	assert.Equal(t, runCount, 3)
+	assert.Equal(t, breakCount, 1)
+	assert.True(t, cb.GetHalfOpenProbeSent())
+}
+
+func TestCircuitBreaker_SlowCall(t *testing.T) {
+	ctx := context.Background()
+	cb := NewCircuitBreaker(NewCircuitBreakerConfig().
+		SetMinQPS(0).
+		SetFailureRateThreshold(50).
+		SetOpenStatusDurationMs(10000).
+		SetSlowCallDurationMs(100).
+		SetSlowCallRateThreshold(50))
+
+	// 慢调用率未超过 50%
+	nowMs := utils.GetNowMs()
+	for i := 0; i < 10; i++ {
+		cb.HitWithDuration(nowMs, false, false, 10)
+	}
+	for i := 0; i < 10; i++ {
+		cb.HitWithDuration(nowMs, false, false, 200)
+	}
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusClosed)
+
+	cb.HitWithDuration(nowMs, false, false, 200)
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusOpen)
+
+	// 慢调用的探测请求记为失败
+	cb = newHalfOpenCircuitBreaker(NewCircuitBreakerConfig().SetSlowCallDurationMs(10))
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusHalfOpen)
+	cb.Do(ctx, func(ctx context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}, nil)
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusOpen)
+}
+
+type testBreakerLog struct {
+	infos, warns []string
+}
+
+func (l *testBreakerLog) Debugf(f string, args ...interface{}) {}
+func (l *testBreakerLog) Infof(f string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(f, args...))
+}
+func (l *testBreakerLog) Warnf(f string, args ...interface{}) {
+	l.warns = append(l.warns, fmt.Sprintf(f, args...))
+}
+func (l *testBreakerLog) Errorf(f string, args ...interface{}) {}
+
+func TestCircuitBreaker_Listener(t *testing.T) {
+	ctx := context.Background()
+	cb := NewCircuitBreaker(NewCircuitBreakerConfig().
+		SetMinQPS(0).
+		SetFailureRateThreshold(50).
+		SetOpenStatusDurationMs(10))
+
+	events := []StateChangeEvent{}
+	log := &testBreakerLog{}
+	cb.AddListener(func(event StateChangeEvent) {
+		events = append(events, event)
+	}, NewLogListener("test", log))
+
+	// closed -> open, qps of the window exceeds minQPS after 10 requests.
+	for i := 0; i < 20; i++ {
+		cb.Do(ctx, func(ctx context.Context) error {
+			return errors.New("just_error")
+		}, nil)
+	}
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].From, CircuitBreakerStatusClosed)
+	assert.Equal(t, events[0].To, CircuitBreakerStatusOpen)
+	assert.Equal(t, events[0].TotalHits, int64(10))
+	assert.Equal(t, events[0].FailureHits, int64(10))
+	assert.True(t, events[0].TimestampMs > 0)
+
+	// open -> half open -> closed
+	time.Sleep(20 * time.Millisecond)
+	cb.Do(ctx, func(ctx context.Context) error {
+		return nil
+	}, nil)
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[1].To, CircuitBreakerStatusHalfOpen)
+	assert.Equal(t, events[2].From, CircuitBreakerStatusHalfOpen)
+	assert.Equal(t, events[2].To, CircuitBreakerStatusClosed)
+
+	// closed -> force open -> closed
+	cb.ChangeConfig(NewCircuitBreakerConfig().SetForceOpen(true))
+	cb.ChangeConfig(NewCircuitBreakerConfig())
+	assert.Equal(t, len(events), 5)
+	assert.Equal(t, events[3].To, CircuitBreakerStatusForceOpen)
+	assert.Equal(t, events[4].To, CircuitBreakerStatusClosed)
+
+	assert.Equal(t, len(log.warns), 2)
+	assert.Equal(t, len(log.infos), 3)
+	assert.Equal(t, log.warns[0][:36], "circuit breaker test closed -> open ")
+}
+
+// 探测失败一次, 返回本次 open 的时长
+func failProbe(t *testing.T, cb *CircuitBreaker) int64 {
+	// 跳过 open 状态
+	cb.mu.Lock()
+	cb.openStartMs -= cb.getOpenDurationMs() + 1
+	cb.mu.Unlock()
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusHalfOpen)
+
+	cb.Do(context.Background(), func(ctx context.Context) error {
+		return errors.New("just_error")
+	}, nil)
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusOpen)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.getOpenDurationMs()
+}
+
+func TestCircuitBreaker_OpenBackoff(t *testing.T) {
+	cb := newHalfOpenCircuitBreaker(NewCircuitBreakerConfig().
+		SetOpenBackoffMultiplier(2).
+		SetMaxOpenStatusDurationMs(50))
+	cb.mu.Lock()
+	cb.openStartMs = utils.GetNowMs()
+	cb.mu.Unlock()
+
+	assert.Equal(t, failProbe(t, cb), int64(20))
+	assert.Equal(t, failProbe(t, cb), int64(40))
+	// capped.
+	assert.Equal(t, failProbe(t, cb), int64(50))
+
+	// reset once closed.
+	cb.mu.Lock()
+	cb.openStartMs -= cb.getOpenDurationMs() + 1
+	cb.mu.Unlock()
+	assert.Nil(t, cb.Do(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, nil))
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusClosed)
+	assert.Equal(t, cb.openBackoffs, int64(0))
+
+	// with jitter.
+	for i := 0; i < 10; i++ {
+		cb := newHalfOpenCircuitBreaker(NewCircuitBreakerConfig().
+			SetOpenBackoffMultiplier(10).
+			SetOpenBackoffJitter(0.5))
+		durationMs := failProbe(t, cb)
+		assert.True(t, durationMs >= 50 && durationMs <= 150, durationMs)
+	}
+
+	// disabled.
+	cb = newHalfOpenCircuitBreaker(NewCircuitBreakerConfig())
+	assert.Equal(t, failProbe(t, cb), int64(10))
+	assert.Equal(t, failProbe(t, cb), int64(10))
+}
+
+func TestCircuitBreaker_ErrorClassifier(t *testing.T) {
+	errBadRequest := errors.New("bad request")
+	cb := NewCircuitBreaker(NewCircuitBreakerConfig().
+		SetFailureRateThreshold(10).
+		SetErrorClassifier(func(ctx context.Context, err error) ErrorClass {
+			if err == errBadRequest {
+				return ErrorClassSuccess
+			}
+			return DefaultErrorClassifier(ctx, err)
+		}))
+
+	fallbackCount := 0
+	fallback := func(ctx context.Context, err error) error {
+		fallbackCount++
+		return err
+	}
+
+	// business errors count as successes, returned without fallback.
+	for i := 0; i < 100; i++ {
+		err := cb.Do(context.Background(), func(ctx context.Context) error {
+			return errBadRequest
+		}, fallback)
+		assert.Equal(t, err, errBadRequest)
+	}
+	assert.Equal(t, fallbackCount, 0)
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusClosed)
+
+	hitsStat := cb.sw.GetHits(utils.GetNowMs(), TotalHit, FailureHit)
+	assert.Equal(t, hitsStat[TotalHit], int64(100))
+	assert.Equal(t, hitsStat[FailureHit], int64(0))
+
+	// the cancellations of the caller are ignored.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 100; i++ {
+		err := cb.Do(ctx, func(ctx context.Context) error {
+			return ctx.Err()
+		}, fallback)
+		assert.Equal(t, err, context.Canceled)
+	}
+	assert.Equal(t, fallbackCount, 0)
+	assert.Equal(t, cb.sw.GetHit(utils.GetNowMs(), TotalHit), int64(100))
+
+	// the cancellations not caused by the caller are failures.
+	err := cb.Do(context.Background(), func(ctx context.Context) error {
+		return context.Canceled
+	}, fallback)
+	assert.Equal(t, err, context.Canceled)
+	assert.Equal(t, fallbackCount, 1)
+	assert.Equal(t, cb.sw.GetHit(utils.GetNowMs(), FailureHit), int64(1))
+}
+
+func TestCircuitBreaker_ErrorClassifier_IgnoredProbe(t *testing.T) {
+	cb := newHalfOpenCircuitBreaker(NewCircuitBreakerConfig())
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusHalfOpen)
+
+	// the ignored probe is released for the next request.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := cb.Do(ctx, func(ctx context.Context) error {
+		return ctx.Err()
+	}, nil)
+	assert.Equal(t, err, context.Canceled)
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusHalfOpen)
+
+	assert.Nil(t, cb.Do(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, nil))
+	assert.Equal(t, cb.Status(), CircuitBreakerStatusClosed)
+}
@@ -0,0 +1,40 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrorClass 决定一次调用的结果如何计入熔断器的统计
+type ErrorClass int
+
+const (
+	// 计为失败
+	ErrorClassFailure ErrorClass = iota
+	// 计为成功, eg: 4xx 等业务错误
+	ErrorClassSuccess
+	// 不计入统计, eg: 调用方取消的请求
+	ErrorClassIgnore
+)
+
+/*
+ErrorClassifier 对 run 的返回值分类，ctx 为传给 run 的 ctx。
+计为失败的错误交由 fallback 处理，计为成功和忽略的错误直接返回给调用方。
+*/
+type ErrorClassifier func(ctx context.Context, err error) ErrorClass
+
+/*
+DefaultErrorClassifier 忽略调用方取消的请求，其余的错误均计为失败。
+
+下游超时(context.DeadlineExceeded)仍计为失败。
+*/
+func DefaultErrorClassifier(ctx context.Context, err error) ErrorClass {
+	if err == nil {
+		return ErrorClassSuccess
+	}
+
+	if errors.Is(err, context.Canceled) && ctx.Err() == context.Canceled {
+		return ErrorClassIgnore
+	}
+	return ErrorClassFailure
+}
@@ -0,0 +1,333 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EAHITechnology/raptor/eredis"
+	"github.com/EAHITechnology/raptor/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	DefaultDistributedKeyPrefix      = "raptor:breaker"
+	DefaultDistributedSyncIntervalMs = int64(500)
+)
+
+var (
+	ErrCircuitBreakerNil = errors.New("circuit breaker nil")
+	ErrRedisClientNil    = errors.New("redis client nil")
+	ErrBreakerNameNil    = errors.New("breaker name nil")
+	ErrBreakerLogNil     = errors.New("breaker log nil")
+)
+
+// RedisClient 为 DistributedBreaker 使用的 redis 命令, eg: *eredis.Redis。
+type RedisClient interface {
+	Exec(cmd string, key interface{}, args ...interface{}) (interface{}, error)
+}
+
+var _ RedisClient = (*eredis.Redis)(nil)
+
+type DistributedConfig struct {
+	keyPrefix      string
+	syncIntervalMs int64
+	// 汇总所有实例滑动窗口内的计数，按汇总的错误率熔断
+	shareCounts bool
+}
+
+func NewDistributedConfig() *DistributedConfig {
+	return &DistributedConfig{
+		keyPrefix:      DefaultDistributedKeyPrefix,
+		syncIntervalMs: DefaultDistributedSyncIntervalMs,
+	}
+}
+
+func (c *DistributedConfig) SetKeyPrefix(keyPrefix string) *DistributedConfig {
+	c.keyPrefix = keyPrefix
+	return c
+}
+
+func (c *DistributedConfig) SetSyncIntervalMs(syncIntervalMs int64) *DistributedConfig {
+	c.syncIntervalMs = syncIntervalMs
+	return c
+}
+
+func (c *DistributedConfig) SetShareCounts(shareCounts bool) *DistributedConfig {
+	c.shareCounts = shareCounts
+	return c
+}
+
+// 一个 cell 内尚未上报的计数
+type distributedCounts struct {
+	total   int64
+	failure int64
+}
+
+/*
+DistributedBreaker 通过 redis 在多个实例间共享 CircuitBreaker 的状态。
+
+每 syncIntervalMs:
+  - 本地进入 open 时，发布 open 的截止时间，其他实例读到后提前进入 open，直到同一截止时间。
+  - 开启 shareCounts 时，上报本地的计数，并按所有实例汇总的错误率判断是否进入 open。
+
+redis 不可用时只打印日志，熔断器退化为本地熔断。
+redis 的读写都在后台 goroutine 中进行，不影响 Do 的耗时。
+*/
+type DistributedBreaker struct {
+	cb     *CircuitBreaker
+	name   string
+	client RedisClient
+	conf   DistributedConfig
+	log    BreakerLog
+
+	lock sync.Mutex
+	// 待发布的 open 截止时间, 0 表示没有
+	publishUntilMs int64 // guarded by lock
+	// 已发布或读到的 open 截止时间
+	remoteUntilMs int64 // guarded by lock
+	// cellStartMs => 尚未上报的计数
+	pending map[int64]*distributedCounts // guarded by lock
+	// redis 不可用
+	degraded bool // guarded by lock
+}
+
+/*
+NewDistributedBreaker 在 ctx 结束前同步 cb 的状态，name 为实例间共享的资源名，
+client 一般为 eredis.GetClient 返回的 *eredis.Redis。
+*/
+func NewDistributedBreaker(ctx context.Context, cb *CircuitBreaker, name string, client RedisClient, conf *DistributedConfig, log BreakerLog) (*DistributedBreaker, error) {
+	if cb == nil {
+		return nil, ErrCircuitBreakerNil
+	}
+
+	if client == nil || utils.IsNil(client) {
+		return nil, ErrRedisClientNil
+	}
+
+	if name == "" {
+		return nil, ErrBreakerNameNil
+	}
+
+	if log == nil || utils.IsNil(log) {
+		return nil, ErrBreakerLogNil
+	}
+
+	d := &DistributedBreaker{
+		cb:      cb,
+		name:    name,
+		client:  client,
+		conf:    *conf,
+		log:     log,
+		pending: make(map[int64]*distributedCounts),
+	}
+	if d.conf.keyPrefix == "" {
+		d.conf.keyPrefix = DefaultDistributedKeyPrefix
+	}
+	if d.conf.syncIntervalMs <= 0 {
+		d.conf.syncIntervalMs = DefaultDistributedSyncIntervalMs
+	}
+
+	cb.AddListener(d.onStateChange)
+	if d.conf.shareCounts {
+		cb.mu.Lock()
+		cb.onHit = d.onHit
+		cb.mu.Unlock()
+	}
+
+	go d.run(ctx)
+	return d, nil
+}
+
+func (d *DistributedBreaker) Do(ctx context.Context, run runFunc, fallback fallbackFunc) error {
+	return d.cb.Do(ctx, run, fallback)
+}
+
+func (d *DistributedBreaker) Status() int32 {
+	return d.cb.Status()
+}
+
+// Degraded 表示 redis 不可用，当前只使用本地状态。
+func (d *DistributedBreaker) Degraded() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.degraded
+}
+
+func (d *DistributedBreaker) stateKey() string {
+	return d.conf.keyPrefix + ":" + d.name + ":open"
+}
+
+func (d *DistributedBreaker) countsKey(cellStartMs int64) string {
+	return d.conf.keyPrefix + ":" + d.name + ":counts:" + strconv.FormatInt(cellStartMs, 10)
+}
+
+func (d *DistributedBreaker) onStateChange(event StateChangeEvent) {
+	if event.To != CircuitBreakerStatusOpen {
+		return
+	}
+
+	untilMs, ok := d.cb.openUntilMs()
+	if !ok {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	// 由其他实例的状态触发的 open 无需再发布
+	if untilMs > d.remoteUntilMs && untilMs > d.publishUntilMs {
+		d.publishUntilMs = untilMs
+	}
+}
+
+// 由 cb 在持有 cb.mu 时调用
+func (d *DistributedBreaker) onHit(nowMs int64, isFailureHit bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	cellStartMs := nowMs - nowMs%d.cb.sw.CellIntervalMs
+	counts, ok := d.pending[cellStartMs]
+	if !ok {
+		counts = &distributedCounts{}
+		d.pending[cellStartMs] = counts
+	}
+
+	counts.total++
+	if isFailureHit {
+		counts.failure++
+	}
+}
+
+func (d *DistributedBreaker) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(d.conf.syncIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.sync(utils.GetNowMs())
+		}
+	}
+}
+
+func (d *DistributedBreaker) sync(nowMs int64) {
+	err := d.publish(nowMs)
+	if err == nil {
+		err = d.loadState(nowMs)
+	}
+	if err == nil && d.conf.shareCounts {
+		err = d.syncCounts(nowMs)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err != nil {
+		if !d.degraded {
+			d.log.Warnf("distributed circuit breaker %s degrade to local, err:%v", d.name, err)
+		}
+		d.degraded = true
+		return
+	}
+
+	if d.degraded {
+		d.log.Infof("distributed circuit breaker %s recovered", d.name)
+	}
+	d.degraded = false
+}
+
+func (d *DistributedBreaker) publish(nowMs int64) error {
+	d.lock.Lock()
+	untilMs := d.publishUntilMs
+	d.lock.Unlock()
+
+	if untilMs <= nowMs {
+		return nil
+	}
+
+	if _, err := d.client.Exec("SET", d.stateKey(), untilMs, "PX", untilMs-nowMs); err != nil {
+		// 下次同步时重试
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if untilMs > d.remoteUntilMs {
+		d.remoteUntilMs = untilMs
+	}
+	if d.publishUntilMs == untilMs {
+		d.publishUntilMs = 0
+	}
+	return nil
+}
+
+// 读取其他实例发布的 open 截止时间
+func (d *DistributedBreaker) loadState(nowMs int64) error {
+	untilMs, err := redis.Int64(d.client.Exec("GET", d.stateKey()))
+	if err == redis.ErrNil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if untilMs <= nowMs {
+		return nil
+	}
+
+	d.lock.Lock()
+	if untilMs > d.remoteUntilMs {
+		d.remoteUntilMs = untilMs
+	}
+	d.lock.Unlock()
+
+	d.cb.tripOpen(nowMs, untilMs)
+	return nil
+}
+
+// 上报本地的计数，读取滑动窗口内所有实例的计数
+func (d *DistributedBreaker) syncCounts(nowMs int64) error {
+	d.lock.Lock()
+	pending := d.pending
+	d.pending = make(map[int64]*distributedCounts)
+	d.lock.Unlock()
+
+	windowMs := d.cb.sw.Size * d.cb.sw.CellIntervalMs
+	// 上报失败的计数直接丢弃
+	for cellStartMs, counts := range pending {
+		if cellStartMs <= nowMs-windowMs {
+			continue
+		}
+
+		key := d.countsKey(cellStartMs)
+		if _, err := d.client.Exec("HINCRBY", key, TotalHit, counts.total); err != nil {
+			return err
+		}
+		if _, err := d.client.Exec("HINCRBY", key, FailureHit, counts.failure); err != nil {
+			return err
+		}
+		if _, err := d.client.Exec("PEXPIRE", key, windowMs*2); err != nil {
+			return err
+		}
+	}
+
+	var total, failure int64
+	cellStartMs := nowMs - nowMs%d.cb.sw.CellIntervalMs
+	for i := int64(0); i < d.cb.sw.Size; i++ {
+		counts, err := redis.Int64Map(d.client.Exec("HGETALL", d.countsKey(cellStartMs-i*d.cb.sw.CellIntervalMs)))
+		if err != nil {
+			return err
+		}
+		total += counts[TotalHit]
+		failure += counts[FailureHit]
+	}
+
+	if d.cb.shouldOpen(total, failure) {
+		d.cb.tripOpen(nowMs, 0)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/EAHITechnology/raptor/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// in-process stand-in of the redis commands used by DistributedBreaker.
+type fakeRedis struct {
+	lock    sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]int64
+	// the redis is unreachable.
+	down bool
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		strings: make(map[string]string),
+		hashes:  make(map[string]map[string]int64),
+	}
+}
+
+func (f *fakeRedis) Exec(cmd string, key interface{}, args ...interface{}) (interface{}, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+
+	k := key.(string)
+	switch cmd {
+	case "SET":
+		f.strings[k] = strconv.FormatInt(args[0].(int64), 10)
+		return "OK", nil
+	case "GET":
+		val, ok := f.strings[k]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(val), nil
+	case "HINCRBY":
+		if _, ok := f.hashes[k]; !ok {
+			f.hashes[k] = make(map[string]int64)
+		}
+		f.hashes[k][args[0].(string)] += args[1].(int64)
+		return f.hashes[k][args[0].(string)], nil
+	case "PEXPIRE":
+		return int64(1), nil
+	case "HGETALL":
+		reply := []interface{}{}
+		for field, val := range f.hashes[k] {
+			reply = append(reply, []byte(field), []byte(strconv.FormatInt(val, 10)))
+		}
+		return reply, nil
+	}
+	return nil, errors.New("unknown command")
+}
+
+func newTestDistributedBreaker(t *testing.T, ctx context.Context, client RedisClient, conf *CircuitBreakerConfig, shareCounts bool) *DistributedBreaker {
+	d, err := NewDistributedBreaker(ctx, NewCircuitBreaker(conf), "user/get", client,
+		// sync manually.
+		NewDistributedConfig().SetSyncIntervalMs(3600000).SetShareCounts(shareCounts), &testBreakerLog{})
+	assert.Nil(t, err)
+	return d
+}
+
+func sendRequests(d *DistributedBreaker, n int, err error) {
+	for i := 0; i < n; i++ {
+		d.Do(context.Background(), func(ctx context.Context) error {
+			return err
+		}, nil)
+	}
+}
+
+func TestDistributedBreaker_State(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newFakeRedis()
+	conf := NewCircuitBreakerConfig().SetFailureRateThreshold(50).SetOpenStatusDurationMs(10000)
+	a := newTestDistributedBreaker(t, ctx, client, conf, false)
+	b := newTestDistributedBreaker(t, ctx, client, conf, false)
+
+	sendRequests(a, 20, errors.New("just_error"))
+	assert.Equal(t, a.Status(), CircuitBreakerStatusOpen)
+	untilMs, ok := a.cb.openUntilMs()
+	assert.True(t, ok)
+
+	nowMs := utils.GetNowMs()
+	a.sync(nowMs)
+	assert.Equal(t, client.strings["raptor:breaker:user/get:open"], strconv.FormatInt(untilMs, 10))
+
+	// b opens early without any failure, until the same time.
+	assert.Equal(t, b.Status(), CircuitBreakerStatusClosed)
+	b.sync(nowMs)
+	assert.Equal(t, b.Status(), CircuitBreakerStatusOpen)
+	bUntilMs, _ := b.cb.openUntilMs()
+	assert.Equal(t, bUntilMs, untilMs)
+
+	// the open status triggered by the other instance is not published again.
+	b.lock.Lock()
+	assert.Equal(t, b.publishUntilMs, int64(0))
+	b.lock.Unlock()
+}
+
+func TestDistributedBreaker_Counts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newFakeRedis()
+	conf := NewCircuitBreakerConfig().SetFailureRateThreshold(50).SetMinQPS(1).SetOpenStatusDurationMs(10000)
+	a := newTestDistributedBreaker(t, ctx, client, conf, true)
+	b := newTestDistributedBreaker(t, ctx, client, conf, true)
+
+	// every instance is under the min qps, 15 requests in the 10s window.
+	sendRequests(a, 15, errors.New("just_error"))
+	sendRequests(b, 15, errors.New("just_error"))
+	assert.Equal(t, a.Status(), CircuitBreakerStatusClosed)
+	assert.Equal(t, b.Status(), CircuitBreakerStatusClosed)
+
+	nowMs := utils.GetNowMs()
+	a.sync(nowMs)
+	assert.Equal(t, a.Status(), CircuitBreakerStatusClosed)
+
+	// 30 requests in total exceed the min qps.
+	b.sync(nowMs)
+	assert.Equal(t, b.Status(), CircuitBreakerStatusOpen)
+
+	a.sync(nowMs)
+	b.sync(nowMs)
+	assert.Equal(t, a.Status(), CircuitBreakerStatusOpen)
+}
+
+func TestDistributedBreaker_Degraded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newFakeRedis()
+	client.down = true
+	conf := NewCircuitBreakerConfig().SetFailureRateThreshold(50).SetOpenStatusDurationMs(10000)
+	a := newTestDistributedBreaker(t, ctx, client, conf, true)
+
+	// the local breaker still works.
+	sendRequests(a, 20, errors.New("just_error"))
+	a.sync(utils.GetNowMs())
+	assert.True(t, a.Degraded())
+	assert.Equal(t, a.Status(), CircuitBreakerStatusOpen)
+
+	// published once the redis is back.
+	client.down = false
+	a.sync(utils.GetNowMs())
+	assert.False(t, a.Degraded())
+	_, ok := client.strings["raptor:breaker:user/get:open"]
+	assert.True(t, ok)
+
+	_, err := NewDistributedBreaker(ctx, NewCircuitBreaker(conf), "user/get", nil, NewDistributedConfig(), &testBreakerLog{})
+	assert.Equal(t, err, ErrRedisClientNil)
+	_, err = NewDistributedBreaker(ctx, NewCircuitBreaker(conf), "user/get", client, NewDistributedConfig(), nil)
+	assert.Equal(t, err, ErrBreakerLogNil)
+}
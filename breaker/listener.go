@@ -0,0 +1,57 @@
+package breaker
+
+type BreakerLog interface {
+	Debugf(f string, args ...interface{})
+	Infof(f string, args ...interface{})
+	Warnf(f string, args ...interface{})
+	Errorf(f string, args ...interface{})
+}
+
+// StateChangeEvent 熔断器的一次状态迁移
+type StateChangeEvent struct {
+	From        int32
+	To          int32
+	TimestampMs int64
+
+	// 迁移时滑动窗口内的统计
+	TotalHits   int64
+	FailureHits int64
+	SlowHits    int64
+}
+
+/*
+StateChangeListener 在熔断器状态迁移后，由触发迁移的 goroutine 调用，调用时不持有熔断器的锁。
+*/
+type StateChangeListener func(event StateChangeEvent)
+
+func StatusName(status int32) string {
+	switch status {
+	case CircuitBreakerStatusClosed:
+		return "closed"
+	case CircuitBreakerStatusOpen:
+		return "open"
+	case CircuitBreakerStatusHalfOpen:
+		return "half_open"
+	case CircuitBreakerStatusForceOpen:
+		return "force_open"
+	default:
+		return "unknown"
+	}
+}
+
+// NewLogListener 打印状态迁移，进入 open 与 force open 时打印 warn 日志，eg: elog.Elog。
+func NewLogListener(name string, log BreakerLog) StateChangeListener {
+	return func(event StateChangeEvent) {
+		f := "circuit breaker %s %s -> %s at %d, total:%d failure:%d slow:%d"
+		args := []interface{}{
+			name, StatusName(event.From), StatusName(event.To), event.TimestampMs,
+			event.TotalHits, event.FailureHits, event.SlowHits,
+		}
+
+		if event.To == CircuitBreakerStatusOpen || event.To == CircuitBreakerStatusForceOpen {
+			log.Warnf(f, args...)
+			return
+		}
+		log.Infof(f, args...)
+	}
+}
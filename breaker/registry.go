@@ -0,0 +1,150 @@
+package breaker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/EAHITechnology/raptor/config"
+	"github.com/EAHITechnology/raptor/utils"
+)
+
+// 未单独配置的资源使用此名称的配置
+const DefaultBreakerName = "default"
+
+var ErrConfigParserNil = errors.New("config parser nil")
+
+// BreakerStatus 资源名及其熔断器的当前状态
+type BreakerStatus struct {
+	Name   string
+	Status int32
+}
+
+/*
+Registry 按资源名(eg: 下游服务 + 接口)懒创建熔断器。
+
+资源的配置取自 config.ConfigParser 中同名的 circuit_breaker 配置，
+没有时取名为 "default" 的配置，都没有时使用默认配置。
+*/
+type Registry struct {
+	parser config.ConfigParser
+	// 不为 nil 时，每个熔断器的状态迁移都会打印日志
+	log BreakerLog
+
+	lock     sync.RWMutex
+	breakers map[string]*CircuitBreaker
+	// 所有熔断器的 ErrorClassifier, 无法通过配置文件配置
+	errorClassifier ErrorClassifier // guarded by lock
+}
+
+func NewRegistry(parser config.ConfigParser, log BreakerLog) (*Registry, error) {
+	if parser == nil || utils.IsNil(parser) {
+		return nil, ErrConfigParserNil
+	}
+
+	return &Registry{
+		parser:   parser,
+		log:      log,
+		breakers: make(map[string]*CircuitBreaker),
+	}, nil
+}
+
+func newCircuitBreakerConfig(info config.CircuitBreakerConfigInfo) *CircuitBreakerConfig {
+	return NewCircuitBreakerConfig().
+		SetMinQPS(info.MinQPS).
+		SetFailureRateThreshold(info.FailureRate).
+		SetFailureNum(info.FailureNum).
+		SetOpenStatusDurationMs(info.OpenDuration).
+		SetForceOpen(info.ForceOpen).
+		SetSize(info.WindowSize).
+		SetCellIntervalMs(info.CellInterval).
+		SetHalfOpenProbes(info.HalfOpenProbes).
+		SetHalfOpenSuccessRatio(info.HalfOpenSuccessRatio).
+		SetSlowCallDurationMs(info.SlowCallDuration).
+		SetSlowCallRateThreshold(info.SlowCallRate).
+		SetOpenBackoffMultiplier(info.OpenBackoffMultiplier).
+		SetMaxOpenStatusDurationMs(info.MaxOpenDuration).
+		SetOpenBackoffJitter(info.OpenBackoffJitter)
+}
+
+// 资源的配置，资源配置整体覆盖默认配置, guarded by lock
+func (r *Registry) getConfig(name string) *CircuitBreakerConfig {
+	if info, ok := r.parser.GetCircuitBreakerConfig(name); ok {
+		return newCircuitBreakerConfig(info).SetErrorClassifier(r.errorClassifier)
+	}
+
+	if info, ok := r.parser.GetCircuitBreakerConfig(DefaultBreakerName); ok {
+		return newCircuitBreakerConfig(info).SetErrorClassifier(r.errorClassifier)
+	}
+	return NewCircuitBreakerConfig().SetErrorClassifier(r.errorClassifier)
+}
+
+// SetErrorClassifier 设置所有熔断器的 ErrorClassifier，nil 表示 DefaultErrorClassifier。
+func (r *Registry) SetErrorClassifier(errorClassifier ErrorClassifier) {
+	r.lock.Lock()
+	r.errorClassifier = errorClassifier
+	r.lock.Unlock()
+
+	r.Reload()
+}
+
+// Get 返回资源的熔断器，不存在时创建。
+func (r *Registry) Get(name string) *CircuitBreaker {
+	r.lock.RLock()
+	cb, ok := r.breakers[name]
+	r.lock.RUnlock()
+	if ok {
+		return cb
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if cb, ok := r.breakers[name]; ok {
+		return cb
+	}
+
+	cb = NewCircuitBreaker(r.getConfig(name))
+	if r.log != nil && !utils.IsNil(r.log) {
+		cb.AddListener(NewLogListener(name, r.log))
+	}
+	r.breakers[name] = cb
+	return cb
+}
+
+/*
+Reload 将 config.ConfigParser 中的最新配置通过 ChangeConfig 应用到已创建的熔断器，
+应在 config.ConfigParser.Reload 之后调用。
+滑动窗口的大小在熔断器创建后不再变化。
+*/
+func (r *Registry) Reload() {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for name, cb := range r.breakers {
+		cb.ChangeConfig(r.getConfig(name))
+	}
+}
+
+// List 按资源名排序返回所有熔断器的当前状态。
+func (r *Registry) List() []BreakerStatus {
+	r.lock.RLock()
+	names := make([]string, 0, len(r.breakers))
+	breakers := make(map[string]*CircuitBreaker, len(r.breakers))
+	for name, cb := range r.breakers {
+		names = append(names, name)
+		breakers[name] = cb
+	}
+	r.lock.RUnlock()
+
+	sort.Strings(names)
+
+	statuses := make([]BreakerStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, BreakerStatus{
+			Name:   name,
+			Status: breakers[name].Status(),
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,77 @@
+package breaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EAHITechnology/raptor/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRegistryConfig = `
+[[circuit_breaker]]
+name="default"
+min_qps=10
+failure_rate=50
+open_duration=5000
+open_backoff_multiplier=2
+
+[[circuit_breaker]]
+name="user/get"
+min_qps=5
+failure_rate=30
+half_open_probes=3
+`
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breaker_registry")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.toml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(testRegistryConfig), 0644))
+
+	parser, err := config.NewConfigParser(config.ConfigCenterInfo{FilePath: path, FileType: "toml"})
+	assert.Nil(t, err)
+
+	r, err := NewRegistry(parser, nil)
+	assert.Nil(t, err)
+
+	// the breakers are created lazily and cached.
+	user := r.Get("user/get")
+	assert.Equal(t, user, r.Get("user/get"))
+	assert.Equal(t, user.config.minQPS, int64(5))
+	assert.Equal(t, user.config.failureRateThreshold, int64(30))
+	assert.Equal(t, user.config.halfOpenProbes, int64(3))
+
+	order := r.Get("order/list")
+	assert.Equal(t, order.config.minQPS, int64(10))
+	assert.Equal(t, order.config.OpenStatusDurationMs, int64(5000))
+	assert.Equal(t, order.config.openBackoffMultiplier, 2.0)
+
+	assert.Equal(t, r.List(), []BreakerStatus{
+		{Name: "order/list", Status: CircuitBreakerStatusClosed},
+		{Name: "user/get", Status: CircuitBreakerStatusClosed},
+	})
+
+	// the changes are applied on Reload.
+	reloaded := testRegistryConfig + `
+[[circuit_breaker]]
+name="order/list"
+force_open=true
+`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(reloaded), 0644))
+	assert.Nil(t, parser.Reload())
+	r.Reload()
+
+	assert.Equal(t, order, r.Get("order/list"))
+	assert.Equal(t, r.List(), []BreakerStatus{
+		{Name: "order/list", Status: CircuitBreakerStatusForceOpen},
+		{Name: "user/get", Status: CircuitBreakerStatusClosed},
+	})
+
+	_, err = NewRegistry(nil, nil)
+	assert.NotNil(t, err)
+}
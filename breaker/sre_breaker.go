@@ -0,0 +1,154 @@
+package breaker
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/EAHITechnology/raptor/utils"
+	"github.com/EAHITechnology/raptor/utils/rand2"
+)
+
+const (
+	// 默认的 K，后端需要拒绝一半的请求时客户端才开始限流。
+	DefaultK = 2.0
+
+	// 被后端接受的请求
+	AcceptHit = "accept"
+)
+
+type SreBreakerConfig struct {
+	// K 越小限流越激进，K 需大于 0
+	k float64
+	// 窗口内的请求数少于此值时不限流
+	minRequests    int64
+	size           int64
+	cellIntervalMs int64
+}
+
+/*
+SreBreaker 为 Google SRE 的客户端自适应限流(adaptive throttling)。
+
+在滑动窗口内统计请求数 requests(含本地拒绝的请求)与后端接受数 accepts，
+以 max(0, (requests - K*accepts) / (requests + 1)) 的概率拒绝请求。
+后端过载时请求被逐步丢弃，而不是像 CircuitBreaker 一样全部拒绝。
+*/
+type SreBreaker struct {
+	mu     sync.Mutex           // guard sw
+	sw     *utils.SlidingWindow // guarded by mu
+	config SreBreakerConfig
+	rand   *rand2.Rand
+}
+
+func NewSreBreakerConfig() *SreBreakerConfig {
+	return &SreBreakerConfig{
+		k: DefaultK,
+	}
+}
+
+func (c *SreBreakerConfig) SetK(k float64) *SreBreakerConfig {
+	c.k = k
+	return c
+}
+
+func (c *SreBreakerConfig) SetMinRequests(minRequests int64) *SreBreakerConfig {
+	c.minRequests = minRequests
+	return c
+}
+
+func (c *SreBreakerConfig) SetSize(size int64) *SreBreakerConfig {
+	c.size = size
+	return c
+}
+
+func (c *SreBreakerConfig) SetCellIntervalMs(cellIntervalMs int64) *SreBreakerConfig {
+	c.cellIntervalMs = cellIntervalMs
+	return c
+}
+
+func NewSreBreaker(config *SreBreakerConfig) *SreBreaker {
+	k := config.k
+	if k <= 0 {
+		k = DefaultK
+	}
+
+	size, cellIntervalMs := config.size, config.cellIntervalMs
+	if size <= 0 || cellIntervalMs <= 0 {
+		size, cellIntervalMs = DefaultSize, DefaultCellIntervalMs
+	}
+
+	return &SreBreaker{
+		sw: utils.NewSlidingWindow(size, cellIntervalMs),
+		config: SreBreakerConfig{
+			k:              k,
+			minRequests:    config.minRequests,
+			size:           size,
+			cellIntervalMs: cellIntervalMs,
+		},
+		rand: rand2.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// RejectProbability 返回当前拒绝请求的概率
+func (b *SreBreaker) RejectProbability(nowMs int64) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rejectProbability(nowMs)
+}
+
+// guarded by mu
+func (b *SreBreaker) rejectProbability(nowMs int64) float64 {
+	hitsStat := b.sw.GetHits(nowMs, TotalHit, AcceptHit)
+	requests, accepts := float64(hitsStat[TotalHit]), float64(hitsStat[AcceptHit])
+	if hitsStat[TotalHit] < b.config.minRequests {
+		return 0
+	}
+	return math.Max(0, (requests-b.config.k*accepts)/(requests+1))
+}
+
+// Allow 判断是否放行请求，被拒绝的请求同样计入 requests。
+func (b *SreBreaker) Allow(nowMs int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := b.rejectProbability(nowMs)
+	if p > 0 && b.rand.Float64() < p {
+		b.sw.Hit(nowMs, TotalHit)
+		return false
+	}
+	return true
+}
+
+// Hit 记录放行的请求是否被后端接受。
+func (b *SreBreaker) Hit(nowMs int64, isFailureHit bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if isFailureHit {
+		b.sw.Hit(nowMs, TotalHit)
+		return
+	}
+	b.sw.Hit(nowMs, TotalHit, AcceptHit)
+}
+
+func (b *SreBreaker) Do(ctx context.Context, run runFunc, fallback fallbackFunc) error {
+	if fallback == nil {
+		fallback = func(ctx context.Context, err error) error {
+			return err
+		}
+	}
+
+	if !b.Allow(utils.GetNowMs()) {
+		return fallback(ctx, ErrCircuitBreak)
+	}
+
+	err := run(ctx)
+	b.Hit(utils.GetNowMs(), err != nil)
+	if err != nil {
+		return fallback(ctx, err)
+	}
+	return nil
+}
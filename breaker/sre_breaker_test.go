@@ -0,0 +1,67 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/EAHITechnology/raptor/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSreBreaker_RejectProbability(t *testing.T) {
+	b := NewSreBreaker(NewSreBreakerConfig())
+	nowMs := utils.GetNowMs()
+
+	// all accepted, nothing rejected.
+	for i := 0; i < 100; i++ {
+		b.Hit(nowMs, false)
+	}
+	assert.Equal(t, b.RejectProbability(nowMs), 0.0)
+
+	// half of the requests accepted, still requests < K*accepts.
+	for i := 0; i < 100; i++ {
+		b.Hit(nowMs, true)
+	}
+	assert.Equal(t, b.RejectProbability(nowMs), 0.0)
+
+	// (400 - 2*100) / 401
+	for i := 0; i < 200; i++ {
+		b.Hit(nowMs, true)
+	}
+	assert.InDelta(t, b.RejectProbability(nowMs), 200.0/401, 1e-9)
+
+	// the stats out of the window are dropped.
+	assert.Equal(t, b.RejectProbability(nowMs+DefaultSize*DefaultCellIntervalMs+1), 0.0)
+}
+
+func TestSreBreaker_Do(t *testing.T) {
+	ctx := context.Background()
+	b := NewSreBreaker(NewSreBreakerConfig().SetMinRequests(10))
+
+	runCount := 0
+	rejectCount := 0
+	for i := 0; i < 1000; i++ {
+		b.Do(ctx, func(ctx context.Context) error {
+			runCount++
+			return errors.New("just_error")
+		}, func(ctx context.Context, err error) error {
+			if err == ErrCircuitBreak {
+				rejectCount++
+			}
+			return err
+		})
+	}
+
+	// the load is shed gradually, a few requests still reach the backend.
+	assert.Equal(t, runCount+rejectCount, 1000)
+	assert.True(t, runCount >= 10 && runCount < 100, runCount)
+	assert.True(t, b.RejectProbability(utils.GetNowMs()) > 0.9)
+
+	// not rejected under minRequests.
+	b = NewSreBreaker(NewSreBreakerConfig().SetMinRequests(10))
+	for i := 0; i < 10; i++ {
+		assert.True(t, b.Allow(utils.GetNowMs()))
+		b.Hit(utils.GetNowMs(), true)
+	}
+}
@@ -22,6 +22,10 @@ func (a *ApolloConfigParser) GetRpcConfig(key string) (RpcNetConfigInfo, bool) {
 	return RpcNetConfigInfo{}, false
 }
 
+func (a *ApolloConfigParser) GetCircuitBreakerConfig(key string) (CircuitBreakerConfigInfo, bool) {
+	return CircuitBreakerConfigInfo{}, false
+}
+
 func (a *ApolloConfigParser) GetDataBaseConfigs() []DatabaseConfigInfo {
 	return nil
 }
@@ -34,6 +38,10 @@ func (a *ApolloConfigParser) GetRpcConfigs() []RpcNetConfigInfo {
 	return nil
 }
 
+func (a *ApolloConfigParser) GetCircuitBreakerConfigs() []CircuitBreakerConfigInfo {
+	return nil
+}
+
 func (a *ApolloConfigParser) Unmarshal(obj interface{}) error {
 	return nil
 }
@@ -21,10 +21,12 @@ type ConfigParser interface {
 	GetDataBaseConfig(key string) (DatabaseConfigInfo, bool)
 	GetRedisConfig(key string) (RedisConfigInfo, bool)
 	GetRpcConfig(key string) (RpcNetConfigInfo, bool)
+	GetCircuitBreakerConfig(key string) (CircuitBreakerConfigInfo, bool)
 
 	GetDataBaseConfigs() []DatabaseConfigInfo
 	GetRedisConfigs() []RedisConfigInfo
 	GetRpcConfigs() []RpcNetConfigInfo
+	GetCircuitBreakerConfigs() []CircuitBreakerConfigInfo
 
 	Unmarshal(obj interface{}) error
 
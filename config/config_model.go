@@ -44,7 +44,7 @@ type RpcNetConfigInfo struct {
 	// Service Discovery Name.
 	ServiceName string `mapstructure:"service_name"`
 
-	// Protocol type, HTTP, HTTPS and grpc are currently supported.
+	// Protocol type, HTTP, HTTPS, grpc and raw tcp are currently supported.
 	Proto string `mapstructure:"proto"`
 
 	// Service discovery type，eg: etcd, zk, apollo, list.
@@ -59,9 +59,75 @@ type RpcNetConfigInfo struct {
 	Wight []int `mapstructure:"wight"`
 
 	// Load balancing type.
-	// eg: consistency_hash, p2c, random, range.
+	// eg: consistency_hash, maglev, p2c, random, range, round_robin.
 	Balancetype string `mapstructure:"balancetype"`
 
+	// ε of the consistent hashing with bounded loads, valid for consistency_hash.
+	// A host takes at most (1+ε) times the average load. 0 means no bound.
+	BoundedLoadFactor float64 `mapstructure:"bounded_load_factor"`
+
+	// Slow start window of the newly added addr, valid for random, p2c and round_robin.
+	// The wight of the addr ramps up to its full wight in the window.(Millisecond, 0 means disabled.)
+	SlowStartWindow int `mapstructure:"slow_start_window"`
+
+	// Shape of the slow start curve, 1 means linear, larger ramps faster at the beginning.(default 1.)
+	SlowStartAggression float64 `mapstructure:"slow_start_aggression"`
+
+	// Key ranges, valid for range balancing.
+	// Every addr owns one range, formatted as "start,end". Empty end means no upper bound.
+	Ranges []string `mapstructure:"ranges"`
+
+	// Every client only talks to a stable subset of the addrs, in the size.
+	// Not valid for range balancing and zone aware balancing. 0 means all the addrs.
+	SubsetSize int `mapstructure:"subset_size"`
+
+	// Zones of the addrs, every addr has one zone.
+	// The hosts in the same zone as the server are preferred. Empty means not zone aware.
+	Zones []string `mapstructure:"zones"`
+
+	// Spill over to the other zones when less than this percent of the local wight is available.(default 70.)
+	ZoneSpilloverThreshold int `mapstructure:"zone_spillover_threshold"`
+
+	// Outlier detection, eject a host after consecutive failures. 0 means disabled.
+	OutlierConsecutiveFailures int `mapstructure:"outlier_consecutive_failures"`
+
+	// Outlier detection, eject a host when its failure rate exceeds. (Percent, 0 means disabled.)
+	OutlierFailureRate int `mapstructure:"outlier_failure_rate"`
+
+	// Ejection time, doubled every time the host is ejected again.(Millisecond default 30000.)
+	OutlierBaseEjectionTime int `mapstructure:"outlier_base_ejection_time"`
+
+	// Max ejection time.(Millisecond default 300000.)
+	OutlierMaxEjectionTime int `mapstructure:"outlier_max_ejection_time"`
+
+	// Max percent of the hosts ejected at the same time.(default 10.)
+	OutlierMaxEjectionPercent int `mapstructure:"outlier_max_ejection_percent"`
+
+	// Active health check type, eg: http, tcp. Empty means disabled.
+	HealthCheckType string `mapstructure:"health_check_type"`
+
+	// Path of the http health check.(default "/".)
+	HealthCheckPath string `mapstructure:"health_check_path"`
+
+	// Health check interval.(Millisecond default 5000.)
+	HealthCheckInterval int `mapstructure:"health_check_interval"`
+
+	// Health check time out.(Millisecond default 1000.)
+	HealthCheckTimeout int `mapstructure:"health_check_timeout"`
+
+	// Successful checks in a row before an unhealthy host is healthy again.(default 2.)
+	HealthyThreshold int `mapstructure:"healthy_threshold"`
+
+	// Failed checks in a row before a host is unhealthy.(default 3.)
+	UnhealthyThreshold int `mapstructure:"unhealthy_threshold"`
+
+	// Circuit breaker of the http calls, eg: service, host. Empty means disabled.
+	// The breakers are configured by the circuit_breaker config named "<service_name>" or "<service_name>/<addr>".
+	Breaker string `mapstructure:"breaker"`
+
+	// Max in-flight http calls of the adaptive concurrency limiter. 0 means disabled.
+	AdaptiveMaxConcurrency int `mapstructure:"adaptive_max_concurrency"`
+
 	// rpc dial time out.(Millisecond default 0.)
 	DialTimeout int `mapstructure:"dial_timeout"`
 
@@ -90,6 +156,55 @@ type RpcNetConfigInfo struct {
 	WriteBufferSize int `mapstructure:"writebuffer_size"`
 }
 
+// circuit breaker of a resource, eg: downstream service + endpoint.
+type CircuitBreakerConfigInfo struct {
+
+	// Resource name. The config named "default" is applied to the resources not configured.
+	Name string `mapstructure:"name"`
+
+	// Open only when the qps in the window exceeds.
+	MinQPS int64 `mapstructure:"min_qps"`
+
+	// Open when the failure rate exceeds.(Percent.)
+	FailureRate int64 `mapstructure:"failure_rate"`
+
+	// Open only when the failures in the window exceed.
+	FailureNum int64 `mapstructure:"failure_num"`
+
+	// Time of the open status before probing.(Millisecond.)
+	OpenDuration int64 `mapstructure:"open_duration"`
+
+	// Reject all the requests.
+	ForceOpen bool `mapstructure:"force_open"`
+
+	// Cells of the sliding window.(default 10.)
+	WindowSize int64 `mapstructure:"window_size"`
+
+	// Interval of every cell.(Millisecond default 1000.)
+	CellInterval int64 `mapstructure:"cell_interval"`
+
+	// Probes let through in the half open status.(default 1.)
+	HalfOpenProbes int64 `mapstructure:"half_open_probes"`
+
+	// Close when the success rate of the probes reaches.(Percent default 100.)
+	HalfOpenSuccessRatio int64 `mapstructure:"half_open_success_ratio"`
+
+	// A request slower than this is a slow call.(Millisecond, 0 means disabled.)
+	SlowCallDuration int64 `mapstructure:"slow_call_duration"`
+
+	// Open when the slow call rate exceeds.(Percent, 0 means disabled.)
+	SlowCallRate int64 `mapstructure:"slow_call_rate"`
+
+	// The open duration is multiplied by this after every failed probe, and reset once closed.(Larger than 1, 0 means disabled.)
+	OpenBackoffMultiplier float64 `mapstructure:"open_backoff_multiplier"`
+
+	// Max open duration of the backoff.(Millisecond, 0 means no limit.)
+	MaxOpenDuration int64 `mapstructure:"max_open_duration"`
+
+	// The open duration of the backoff varies randomly by this fraction.(0 to 1.)
+	OpenBackoffJitter float64 `mapstructure:"open_backoff_jitter"`
+}
+
 type ServiceDiscovery struct {
 	EtcdAddr               []string `mapstructure:"etcd_addr"`
 	ZkAddr                 []string `mapstructure:"zk_addr"`
@@ -101,4 +216,6 @@ type Config struct {
 	DatabaseConfigs  []DatabaseConfigInfo `mapstructure:"database"`
 	RedisConfigs     []RedisConfigInfo    `mapstructure:"redis"`
 	RpcNetConfigs    []RpcNetConfigInfo   `mapstructure:"rpc_server_client"`
+
+	CircuitBreakerConfigs []CircuitBreakerConfigInfo `mapstructure:"circuit_breaker"`
 }
@@ -22,6 +22,10 @@ func (e *EtcdConfigParser) GetRpcConfig(key string) (RpcNetConfigInfo, bool) {
 	return RpcNetConfigInfo{}, false
 }
 
+func (e *EtcdConfigParser) GetCircuitBreakerConfig(key string) (CircuitBreakerConfigInfo, bool) {
+	return CircuitBreakerConfigInfo{}, false
+}
+
 func (e *EtcdConfigParser) GetDataBaseConfigs() []DatabaseConfigInfo {
 	return nil
 }
@@ -34,6 +38,10 @@ func (e *EtcdConfigParser) GetRpcConfigs() []RpcNetConfigInfo {
 	return nil
 }
 
+func (e *EtcdConfigParser) GetCircuitBreakerConfigs() []CircuitBreakerConfigInfo {
+	return nil
+}
+
 func (e *EtcdConfigParser) Unmarshal(obj interface{}) error {
 	return nil
 }
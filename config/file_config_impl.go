@@ -16,6 +16,7 @@ type FileConfigParser struct {
 	databaseMap map[string]DatabaseConfigInfo
 	redisMap    map[string]RedisConfigInfo
 	rpcMap      map[string]RpcNetConfigInfo
+	breakerMap  map[string]CircuitBreakerConfigInfo
 
 	lock sync.RWMutex
 }
@@ -26,6 +27,7 @@ func NewFileConfigParser(configCenter ConfigCenterInfo) (ConfigParser, error) {
 	fc.databaseMap = make(map[string]DatabaseConfigInfo)
 	fc.redisMap = make(map[string]RedisConfigInfo)
 	fc.rpcMap = make(map[string]RpcNetConfigInfo)
+	fc.breakerMap = make(map[string]CircuitBreakerConfigInfo)
 
 	if err := fc.loadConfig(); err != nil {
 		return nil, err
@@ -76,6 +78,12 @@ func (f *FileConfigParser) loadConfig() error {
 	}
 	f.rpcMap = rpcMap
 
+	breakerMap := make(map[string]CircuitBreakerConfigInfo)
+	for _, v := range f.config.CircuitBreakerConfigs {
+		breakerMap[v.Name] = v
+	}
+	f.breakerMap = breakerMap
+
 	return nil
 }
 
@@ -125,6 +133,13 @@ func (f *FileConfigParser) GetRpcConfig(key string) (RpcNetConfigInfo, bool) {
 	return val, ok
 }
 
+func (f *FileConfigParser) GetCircuitBreakerConfig(key string) (CircuitBreakerConfigInfo, bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	val, ok := f.breakerMap[key]
+	return val, ok
+}
+
 func (f *FileConfigParser) GetDataBaseConfigs() []DatabaseConfigInfo {
 	f.lock.RLock()
 	defer f.lock.RUnlock()
@@ -143,6 +158,12 @@ func (f *FileConfigParser) GetRpcConfigs() []RpcNetConfigInfo {
 	return f.config.RpcNetConfigs
 }
 
+func (f *FileConfigParser) GetCircuitBreakerConfigs() []CircuitBreakerConfigInfo {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.config.CircuitBreakerConfigs
+}
+
 func (f *FileConfigParser) Unmarshal(obj interface{}) error {
 	f.lock.RLock()
 	defer f.lock.RUnlock()
@@ -4,6 +4,8 @@ type ServerConfigInfo struct {
 	ServiceName string `mapstructure:"service_name"`
 	HttpPort    string `mapstructure:"http_port"`
 	RpcPort     string `mapstructure:"rpc_port"`
+	// 服务所在的可用区, 用于同区优先的负载均衡
+	Zone string `mapstructure:"zone"`
 }
 
 type LogConfigInfo struct {
@@ -0,0 +1,59 @@
+package enet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/EAHITechnology/raptor/breaker"
+)
+
+// the default fallback response while the breaker is open.
+func defaultBreakerFallback(c *Context) {
+	c.JSON(http.StatusServiceUnavailable, CreateJsonResp(http.StatusServiceUnavailable, breaker.ErrCircuitBreak.Error()))
+}
+
+/*
+BreakerMiddle protects the handlers after it with the breaker, eg:
+
+	r.Get("/user", enet.BreakerMiddle(registry.Get("user"), nil), handler)
+
+A 5xx status, a panic or an error attached to the context counts as a failure.
+While the breaker is open, the handlers are skipped and fallback writes the response.
+A nil fallback responds 503 with a CommonJsonResp.
+*/
+func BreakerMiddle(b breaker.Breaker, fallback HandlerFunc) HandlerFunc {
+	if fallback == nil {
+		fallback = defaultBreakerFallback
+	}
+
+	return func(c *Context) {
+		var panicked interface{}
+		err := b.Do(c.Request.Context(), func(ctx context.Context) (err error) {
+			defer func() {
+				// a panic counts as a failure, it is re-panicked after the breaker records it.
+				if r := recover(); r != nil {
+					panicked = r
+					err = fmt.Errorf("handler panic : %v", r)
+				}
+			}()
+
+			c.Next()
+			if c.Writer.Status() >= http.StatusInternalServerError {
+				return fmt.Errorf("http status : %d", c.Writer.Status())
+			}
+			if len(c.Errors) > 0 {
+				return c.Errors.Last().Err
+			}
+			return nil
+		}, nil)
+
+		if panicked != nil {
+			panic(panicked)
+		}
+		if err == breaker.ErrCircuitBreak {
+			c.Abort()
+			fallback(c)
+		}
+	}
+}
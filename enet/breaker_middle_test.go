@@ -0,0 +1,94 @@
+package enet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EAHITechnology/raptor/breaker"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerMiddle(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+
+	cb := breaker.NewCircuitBreaker(breaker.NewCircuitBreakerConfig().
+		SetFailureRateThreshold(50).
+		SetOpenStatusDurationMs(10000))
+	calls := 0
+	engine.GET("/user", handle(BreakerMiddle(cb, nil)), handle(func(c *Context) {
+		calls++
+		c.JSON(http.StatusInternalServerError, CreateJsonResp(http.StatusInternalServerError, "error"))
+	}))
+
+	fallbackCb := breaker.NewCircuitBreaker(breaker.NewCircuitBreakerConfig().SetForceOpen(true))
+	engine.GET("/order", handle(BreakerMiddle(fallbackCb, func(c *Context) {
+		c.String(http.StatusOK, "fallback")
+	})), handle(func(c *Context) {
+		c.String(http.StatusOK, "order")
+	}))
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+		if i < 10 {
+			assert.Equal(t, w.Code, http.StatusInternalServerError)
+			continue
+		}
+		// the default fallback.
+		assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+	}
+	assert.Equal(t, calls, 10)
+	assert.Equal(t, cb.Status(), breaker.CircuitBreakerStatusOpen)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/order", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "fallback")
+}
+
+func TestBreakerMiddle_Panic(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err interface{}) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+
+	cb := breaker.NewCircuitBreaker(breaker.NewCircuitBreakerConfig().
+		SetFailureRateThreshold(50).
+		SetOpenStatusDurationMs(50))
+	engine.GET("/user", handle(BreakerMiddle(cb, nil)), handle(func(c *Context) {
+		switch {
+		case c.Query("panic") != "":
+			panic("user")
+		case c.Query("fail") != "":
+			c.JSON(http.StatusInternalServerError, CreateJsonResp(http.StatusInternalServerError, "error"))
+		default:
+			c.String(http.StatusOK, "user")
+		}
+	}))
+
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user?fail=1", nil))
+	}
+	assert.Equal(t, cb.Status(), breaker.CircuitBreakerStatusOpen)
+
+	// the probe panics, the breaker opens again.
+	time.Sleep(60 * time.Millisecond)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user?panic=1", nil))
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	assert.Equal(t, cb.Status(), breaker.CircuitBreakerStatusOpen)
+
+	// the next probe is sent and closes the breaker.
+	time.Sleep(60 * time.Millisecond)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "user")
+}
@@ -0,0 +1,59 @@
+package enet
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/EAHITechnology/raptor/limiter"
+)
+
+// the default fallback response while the in-flight requests reach the limit.
+func defaultLimiterFallback(c *Context) {
+	c.JSON(http.StatusServiceUnavailable, CreateJsonResp(http.StatusServiceUnavailable, limiter.ErrRateLimited.Error()))
+}
+
+/*
+AdaptiveLimitMiddle limits the in-flight requests of the handlers after it, eg:
+
+	r.Get("/user", enet.AdaptiveLimitMiddle(l, nil), handler)
+
+The latency of the handlers adjusts the limit. A 503 status, a timeout or a panic counts as a drop.
+While the in-flight requests reach the limit, the handlers are skipped and fallback writes the response.
+A nil fallback responds 503 with a CommonJsonResp.
+*/
+func AdaptiveLimitMiddle(l *limiter.AdaptiveLimiter, fallback HandlerFunc) HandlerFunc {
+	if fallback == nil {
+		fallback = defaultLimiterFallback
+	}
+
+	return func(c *Context) {
+		token, err := l.Acquire()
+		if err != nil {
+			c.Abort()
+			fallback(c)
+			return
+		}
+
+		defer func() {
+			// a panic counts as a drop, the token is released before the recovery handles it.
+			if r := recover(); r != nil {
+				token.Release(limiter.AdaptiveDropped, token.Latency())
+				panic(r)
+			}
+		}()
+
+		c.Next()
+
+		outcome := limiter.AdaptiveSuccess
+		switch {
+		case c.Writer.Status() == http.StatusServiceUnavailable,
+			errors.Is(c.Request.Context().Err(), context.DeadlineExceeded):
+			outcome = limiter.AdaptiveDropped
+		case c.Request.Context().Err() != nil:
+			// the client is gone.
+			outcome = limiter.AdaptiveIgnore
+		}
+		token.Release(outcome, token.Latency())
+	}
+}
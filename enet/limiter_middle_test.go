@@ -0,0 +1,75 @@
+package enet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EAHITechnology/raptor/limiter"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimitMiddle(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+
+	l := limiter.NewAdaptiveLimiter(limiter.NewAdaptiveLimiterConfig().SetInitialLimit(1).SetMaxLimit(1))
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	engine.GET("/user", handle(AdaptiveLimitMiddle(l, nil)), handle(func(c *Context) {
+		if c.Query("block") != "" {
+			close(entered)
+			<-release
+		}
+		c.String(http.StatusOK, "user")
+	}))
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user?block=1", nil))
+		done <- w.Code
+	}()
+	<-entered
+
+	// the default fallback.
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+
+	close(release)
+	assert.Equal(t, <-done, http.StatusOK)
+	assert.Equal(t, l.GetInflight(), int64(0))
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "user")
+}
+
+func TestAdaptiveLimitMiddle_Panic(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(gin.CustomRecovery(func(c *gin.Context, err interface{}) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+
+	l := limiter.NewAdaptiveLimiter(limiter.NewAdaptiveLimiterConfig().SetInitialLimit(1).SetMaxLimit(1))
+	engine.GET("/user", handle(AdaptiveLimitMiddle(l, nil)), handle(func(c *Context) {
+		if c.Query("panic") != "" {
+			panic("user")
+		}
+		c.String(http.StatusOK, "user")
+	}))
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user?panic=1", nil))
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+
+	// the token is released, the next request is not limited.
+	assert.Equal(t, l.GetInflight(), int64(0))
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+}
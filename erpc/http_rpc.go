@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/EAHITechnology/raptor/balancer"
+	"github.com/EAHITechnology/raptor/breaker"
+	"github.com/EAHITechnology/raptor/limiter"
 	"github.com/EAHITechnology/raptor/utils"
 	"golang.org/x/net/context"
 )
@@ -21,6 +24,9 @@ var (
 	ErrBalancerNil          = errors.New("balancer nil")
 	ErrServiceNotExists     = errors.New("service not exists")
 	ErrServiceAlreadyExists = errors.New("service already exists")
+	ErrConnPoolNil          = errors.New("conn pool nil")
+	ErrBreakerTypIllegal    = errors.New("breaker typ illegal")
+	ErrBreakerRegistryNil   = errors.New("breaker registry nil")
 )
 
 type HttpMethod string
@@ -37,14 +43,24 @@ const (
 	DefaultHttpIdleTimeout = 10
 )
 
+// the calls share a breaker.
+const (
+	// one breaker for the service, named by the service name.
+	BreakerByService = "service"
+	// one breaker for every host, named "<service name>/<addr>".
+	BreakerByHost = "host"
+)
+
 func (h *HttpMethod) getMethod() string {
 	return string(*h)
 }
 
 type HttpClientConfig struct {
-	// fuse flag todo
-	// breakFlag bool
 	BaseConfig RpcNetConfigInfo
+	// the breakers of the calls, valid when BaseConfig.Breaker is set.
+	Breakers *breaker.Registry
+	// the adaptive concurrency limiter of the calls, nil means disabled.
+	Limiter *limiter.AdaptiveLimiter
 }
 
 type HttpManagerConfig struct {
@@ -67,6 +83,16 @@ type HttpClientManager struct {
 func NewHttpClient(conf *HttpClientConfig, b balancer.Balancer) (*HttpClient, error) {
 	h := &HttpClient{}
 
+	switch conf.BaseConfig.Breaker {
+	case "":
+	case BreakerByService, BreakerByHost:
+		if conf.Breakers == nil {
+			return nil, ErrBreakerRegistryNil
+		}
+	default:
+		return nil, ErrBreakerTypIllegal
+	}
+
 	if conf.BaseConfig.IdleConnTimeout < DefaultHttpIdleTimeout {
 		conf.BaseConfig.IdleConnTimeout = DefaultHttpIdleTimeout
 	}
@@ -92,15 +118,132 @@ func NewHttpClient(conf *HttpClientConfig, b balancer.Balancer) (*HttpClient, er
 	return h, nil
 }
 
-func (h *HttpClient) Send(ctx context.Context, method HttpMethod, key []byte, uri string, query url.Values, header map[string]string, body io.Reader) ([]byte, error) {
+// the non 200 response.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("http resp status : %d,  msg: %s", e.code, e.msg)
+}
+
+// StatusCode lets the outlier detection tell the 5xx responses from the 4xx ones.
+func (e *statusError) StatusCode() int {
+	return e.code
+}
+
+/*
+the outcome of a call for the adaptive concurrency limiter.
+The timeouts and the overload responses are dropped,
+the other errors say nothing about the latency and are ignored.
+*/
+func adaptiveOutcome(err error) limiter.AdaptiveOutcome {
+	if err == nil {
+		return limiter.AdaptiveSuccess
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return limiter.AdaptiveDropped
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return limiter.AdaptiveDropped
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) &&
+		(statusErr.code == http.StatusServiceUnavailable || statusErr.code == http.StatusTooManyRequests) {
+		return limiter.AdaptiveDropped
+	}
+
+	return limiter.AdaptiveIgnore
+}
+
+/*
+Send picks a host and sends the request.
+
+With a limiter, limiter.ErrRateLimited is returned while the in-flight calls reach the limit.
+With a breaker, breaker.ErrCircuitBreak is returned while the breaker is open.
+The service breaker rejects the request before picking a host,
+and the request rejected by the host breaker is reported to the balancer as skipped.
+*/
+func (h *HttpClient) Send(ctx context.Context, method HttpMethod, key []byte, uri string, query url.Values, header map[string]string, body io.Reader) (respB []byte, err error) {
 	h.lock.RLock()
 	defer h.lock.RUnlock()
 
-	hostInfo, err := h.b.Pick(key)
+	if h.conf.Limiter == nil {
+		return h.breakerSend(ctx, method, key, uri, query, header, body)
+	}
+
+	token, err := h.conf.Limiter.Acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	respB, err = h.breakerSend(ctx, method, key, uri, query, header, body)
+	token.Release(adaptiveOutcome(err), time.Since(start))
+	return respB, err
+}
+
+func (h *HttpClient) breakerSend(ctx context.Context, method HttpMethod, key []byte, uri string, query url.Values, header map[string]string, body io.Reader) (respB []byte, err error) {
+	if h.conf.BaseConfig.Breaker != BreakerByService {
+		return h.send(ctx, method, key, uri, query, header, body)
+	}
+
+	err = h.conf.Breakers.Get(h.conf.BaseConfig.ServiceName).Do(ctx, func(ctx context.Context) error {
+		respB, err = h.send(ctx, method, key, uri, query, header, body)
+		return err
+	}, nil)
+	return respB, err
+}
+
+func (h *HttpClient) send(ctx context.Context, method HttpMethod, key []byte, uri string, query url.Values, header map[string]string, body io.Reader) (respB []byte, err error) {
+	hostInfo, done, err := h.b.Pick(key)
 	if err != nil {
 		return nil, err
 	}
 
+	if h.conf.BaseConfig.Breaker != BreakerByHost {
+		// report the result to the balancer.
+		start := time.Now()
+		respB, err = h.request(ctx, hostInfo.GetAddr(), method, uri, query, header, body)
+		done(balancer.DoneInfo{
+			Err:     err,
+			Latency: time.Since(start),
+		})
+		return respB, err
+	}
+
+	sent := false
+	start := time.Now()
+	name, err := utils.Write(h.conf.BaseConfig.ServiceName, "/", hostInfo.GetAddr())
+	if err != nil {
+		done(balancer.DoneInfo{Skipped: true})
+		return nil, err
+	}
+
+	err = h.conf.Breakers.Get(name).Do(ctx, func(ctx context.Context) error {
+		sent = true
+		respB, err = h.request(ctx, hostInfo.GetAddr(), method, uri, query, header, body)
+		return err
+	}, nil)
+	if !sent {
+		done(balancer.DoneInfo{Skipped: true})
+		return nil, err
+	}
+
+	done(balancer.DoneInfo{
+		Err:     err,
+		Latency: time.Since(start),
+	})
+	return respB, err
+}
+
+func (h *HttpClient) request(ctx context.Context, addr string, method HttpMethod, uri string, query url.Values, header map[string]string, body io.Reader) ([]byte, error) {
+	var err error
 	var q string = ""
 	if query.Encode() != "" {
 		q, err = utils.Write("?", query.Encode())
@@ -109,7 +252,7 @@ func (h *HttpClient) Send(ctx context.Context, method HttpMethod, key []byte, ur
 		}
 	}
 
-	url, err := utils.Write(h.conf.BaseConfig.Proto, "://", hostInfo.GetAddr(), uri, q)
+	url, err := utils.Write(h.conf.BaseConfig.Proto, "://", addr, uri, q)
 	if err != nil {
 		return nil, err
 	}
@@ -135,12 +278,13 @@ func (h *HttpClient) Send(ctx context.Context, method HttpMethod, key []byte, ur
 	}
 
 	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("http resp status : %d,  msg: %s", response.StatusCode, string(respB))
+		return nil, &statusError{code: response.StatusCode, msg: string(respB)}
 	}
 
 	return respB, nil
 }
 
+// AddAddr adds an addr, a zone aware client rejects it as it has no zone, see AddZoneAddr.
 func (h *HttpClient) AddAddr(addr string, wight int) error {
 	h.lock.Lock()
 	defer h.lock.Unlock()
@@ -153,6 +297,33 @@ func (h *HttpClient) AddAddr(addr string, wight int) error {
 	return nil
 }
 
+// AddRangeAddr adds an addr owning the keys in [start, end), valid for range balancing.
+// The range is split out of the ranges it overlaps.
+func (h *HttpClient) AddRangeAddr(addr string, wight int, start, end string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	item := balancer.NewRangeBalancerItem(addr, wight, start, end)
+	if err := h.b.Add(item); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddZoneAddr adds an addr in the zone, valid for zone aware balancing.
+func (h *HttpClient) AddZoneAddr(addr string, wight int, zone string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	item := balancer.NewZoneBalancerItem(addr, wight, zone)
+	if err := h.b.Add(item); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (h *HttpClient) RemoveAddr(addr string) error {
 	h.lock.Lock()
 	defer h.lock.Unlock()
@@ -0,0 +1,129 @@
+package erpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/EAHITechnology/raptor/balancer"
+	"github.com/EAHITechnology/raptor/breaker"
+	"github.com/EAHITechnology/raptor/config"
+	"github.com/EAHITechnology/raptor/limiter"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+const testBreakerConfig = `
+[[circuit_breaker]]
+name="default"
+failure_rate=50
+open_duration=10000
+`
+
+func newTestBreakerRegistry(t *testing.T) *breaker.Registry {
+	dir, err := ioutil.TempDir("", "erpc_breaker")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.toml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(testBreakerConfig), 0644))
+
+	parser, err := config.NewConfigParser(config.ConfigCenterInfo{FilePath: path, FileType: "toml"})
+	assert.Nil(t, err)
+
+	r, err := breaker.NewRegistry(parser, nil)
+	assert.Nil(t, err)
+	return r
+}
+
+func TestHttpClient_Breaker(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	for _, typ := range []string{BreakerByService, BreakerByHost} {
+		atomic.StoreInt64(&calls, 0)
+		registry := newTestBreakerRegistry(t)
+
+		conf := balancer.NewBalancerConfig()
+		conf.SetBalancerTyp(balancer.RoundRobinType)
+		conf.SetItem(balancer.NewBalancerItem(addr, 1))
+		b, err := balancer.NewBalancer(*conf)
+		assert.Nil(t, err)
+
+		client, err := NewHttpClient(&HttpClientConfig{
+			BaseConfig: RpcNetConfigInfo{ServiceName: "user", Proto: "http", Breaker: typ},
+			Breakers:   registry,
+		}, b)
+		assert.Nil(t, err)
+
+		for i := 0; i < 20; i++ {
+			_, err := client.Send(context.Background(), GET, nil, "/user", nil, nil, nil)
+			assert.NotNil(t, err)
+			if i >= 10 {
+				assert.Equal(t, err, breaker.ErrCircuitBreak)
+			}
+		}
+		assert.Equal(t, atomic.LoadInt64(&calls), int64(10), typ)
+
+		name := "user"
+		if typ == BreakerByHost {
+			name = "user/" + addr
+		}
+		assert.Equal(t, registry.List(), []breaker.BreakerStatus{{Name: name, Status: breaker.CircuitBreakerStatusOpen}})
+	}
+
+	_, err := NewHttpClient(&HttpClientConfig{
+		BaseConfig: RpcNetConfigInfo{ServiceName: "user", Proto: "http", Breaker: BreakerByHost},
+	}, nil)
+	assert.Equal(t, err, ErrBreakerRegistryNil)
+}
+
+func TestHttpClient_Limiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	conf := balancer.NewBalancerConfig()
+	conf.SetBalancerTyp(balancer.RoundRobinType)
+	conf.SetItem(balancer.NewBalancerItem(strings.TrimPrefix(server.URL, "http://"), 1))
+	b, err := balancer.NewBalancer(*conf)
+	assert.Nil(t, err)
+
+	l := limiter.NewAdaptiveLimiter(limiter.NewAdaptiveLimiterConfig().SetInitialLimit(10))
+	client, err := NewHttpClient(&HttpClientConfig{
+		BaseConfig: RpcNetConfigInfo{ServiceName: "user", Proto: "http"},
+		Limiter:    l,
+	}, b)
+	assert.Nil(t, err)
+
+	// the overload responses shrink the limit.
+	for i := 0; i < 20; i++ {
+		_, err := client.Send(context.Background(), GET, nil, "/user", nil, nil, nil)
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, l.GetLimit(), limiter.DefaultAdaptiveMinLimit)
+	assert.Equal(t, l.GetInflight(), int64(0))
+
+	token, err := l.Acquire()
+	assert.Nil(t, err)
+	_, err = client.Send(context.Background(), GET, nil, "/user", nil, nil, nil)
+	assert.Equal(t, err, limiter.ErrRateLimited)
+	token.Release(limiter.AdaptiveIgnore, 0)
+}
+
+func TestStatusError_Outlier(t *testing.T) {
+	// only the 5xx responses count as the failures of the host.
+	assert.Equal(t, balancer.DefaultOutlierErrorClassifier(&statusError{code: http.StatusNotFound}), balancer.OutlierClassSuccess)
+	assert.Equal(t, balancer.DefaultOutlierErrorClassifier(&statusError{code: http.StatusBadGateway}), balancer.OutlierClassFailure)
+}
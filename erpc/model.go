@@ -5,7 +5,7 @@ type RpcNetConfigInfo struct {
 	// Service Discovery Name, compatible dirpc.
 	ServiceName string
 
-	// Protocol type, HTTP, HTTPS and grpc are currently supported.
+	// Protocol type, HTTP, HTTPS, grpc and raw tcp are currently supported.
 	Proto string
 
 	// Service discovery type，eg: etcd, zk, apollo, list.
@@ -20,9 +20,70 @@ type RpcNetConfigInfo struct {
 	Wight []int
 
 	// Load balancing type.
-	// eg: consistency_hash, p2c, random, range.
+	// eg: consistency_hash, maglev, p2c, random, range, round_robin.
 	Balancetype string
 
+	// ε of the consistent hashing with bounded loads, valid for consistency_hash.
+	// A host takes at most (1+ε) times the average load. 0 means no bound.
+	BoundedLoadFactor float64
+
+	// Slow start window (ms) of the newly added addr, valid for random, p2c and round_robin.
+	SlowStartWindow int
+
+	// Shape of the slow start curve, 1 means linear.
+	SlowStartAggression float64
+
+	// Key ranges, valid for range balancing.
+	Ranges []string
+
+	// Every client only talks to a stable subset of the addrs, in the size. 0 means all the addrs.
+	SubsetSize int
+
+	// Zones of the addrs, every addr has one zone.
+	Zones []string
+
+	// Spill over to the other zones when less than this percent of the local wight is available.
+	ZoneSpilloverThreshold int
+
+	// Outlier detection, eject a host after consecutive failures. 0 means disabled.
+	OutlierConsecutiveFailures int
+
+	// Outlier detection, eject a host when its failure rate (percent) exceeds. 0 means disabled.
+	OutlierFailureRate int
+
+	// Ejection time (ms), doubled every time the host is ejected again.
+	OutlierBaseEjectionTime int
+
+	// Max ejection time (ms).
+	OutlierMaxEjectionTime int
+
+	// Max percent of the hosts ejected at the same time.
+	OutlierMaxEjectionPercent int
+
+	// Active health check type, eg: http, tcp. Empty means disabled.
+	HealthCheckType string
+
+	// Path of the http health check.
+	HealthCheckPath string
+
+	// Health check interval (ms).
+	HealthCheckInterval int
+
+	// Health check time out (ms).
+	HealthCheckTimeout int
+
+	// Successful checks in a row before an unhealthy host is healthy again.
+	HealthyThreshold int
+
+	// Failed checks in a row before a host is unhealthy.
+	UnhealthyThreshold int
+
+	// Circuit breaker of the http calls, eg: service, host. Empty means disabled.
+	Breaker string
+
+	// Max in-flight http calls of the adaptive concurrency limiter. 0 means disabled.
+	AdaptiveMaxConcurrency int
+
 	// rpc dial time out
 	DialTimeout int
 
@@ -0,0 +1,130 @@
+package erpc
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EAHITechnology/raptor/balancer"
+)
+
+var TcpManager *TcpClientManager
+
+type TcpManagerConfig struct {
+	BaseConfig RpcNetConfigInfo
+	Balancer   balancer.Balancer
+}
+
+/*
+TcpClient borrows the pooled conns of the raw tcp protocols from the balancer.
+*/
+type TcpClient struct {
+	conf RpcNetConfigInfo
+	b    balancer.Balancer
+}
+
+type TcpClientManager struct {
+	manager map[string]*TcpClient
+	lock    sync.RWMutex
+}
+
+func NewTcpClient(conf RpcNetConfigInfo, b balancer.Balancer) (*TcpClient, error) {
+	if b == nil {
+		return nil, ErrBalancerNil
+	}
+
+	return &TcpClient{
+		conf: conf,
+		b:    b,
+	}, nil
+}
+
+/*
+GetConn picks a host by the key and borrows a conn of it.
+Close the conn to return it to the pool, or Discard it when it is broken:
+
+	conn, done, err := client.GetConn(key)
+	...
+	if err != nil {
+		conn.(*balancer.PoolConn).Discard()
+	} else {
+		conn.Close()
+	}
+	done(err)
+
+done reports the result to the balancer.
+*/
+func (t *TcpClient) GetConn(key []byte) (net.Conn, func(err error), error) {
+	hostInfo, done, err := t.b.Pick(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start := time.Now()
+	conn, err := hostInfo.GetHost()
+	if err == nil && conn == nil {
+		err = ErrConnPoolNil
+	}
+	if err != nil {
+		done(balancer.DoneInfo{Err: err, Latency: time.Since(start)})
+		return nil, nil, err
+	}
+
+	return conn, func(err error) {
+		done(balancer.DoneInfo{Err: err, Latency: time.Since(start)})
+	}, nil
+}
+
+func (t *TcpClient) AddAddr(addr string, wight int) error {
+	return t.b.Add(balancer.NewBalancerItem(addr, wight))
+}
+
+func (t *TcpClient) RemoveAddr(addr string) error {
+	return t.b.Remove(addr)
+}
+
+func NewTcpClientManager() *TcpClientManager {
+	return &TcpClientManager{
+		manager: make(map[string]*TcpClient),
+	}
+}
+
+func (tm *TcpClientManager) NewTcpClient(conf RpcNetConfigInfo, b balancer.Balancer) error {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	if _, ok := tm.manager[conf.ServiceName]; ok {
+		return ErrServiceAlreadyExists
+	}
+
+	client, err := NewTcpClient(conf, b)
+	if err != nil {
+		return err
+	}
+
+	tm.manager[conf.ServiceName] = client
+	return nil
+}
+
+func (tm *TcpClientManager) GetClient(serviceName string) (*TcpClient, error) {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	client, ok := tm.manager[serviceName]
+	if !ok {
+		return nil, ErrServiceNotExists
+	}
+
+	return client, nil
+}
+
+func NewSingleTcpClientManager(conf []TcpManagerConfig) error {
+	manager := NewTcpClientManager()
+	for _, val := range conf {
+		if err := manager.NewTcpClient(val.BaseConfig, val.Balancer); err != nil {
+			return err
+		}
+	}
+	TcpManager = manager
+	return nil
+}
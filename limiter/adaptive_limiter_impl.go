@@ -0,0 +1,235 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAdaptiveInitialLimit = int64(20)
+	DefaultAdaptiveMinLimit     = int64(1)
+	DefaultAdaptiveMaxLimit     = int64(1000)
+	DefaultAdaptiveAlpha        = 3.0
+	DefaultAdaptiveBeta         = 6.0
+	DefaultAdaptiveProbeSamples = int64(1000)
+)
+
+// AdaptiveOutcome 请求的结果
+type AdaptiveOutcome int
+
+const (
+	// 请求成功，延迟计入 rtt
+	AdaptiveSuccess AdaptiveOutcome = iota
+	// 请求因过载被丢弃或超时，降低并发数
+	AdaptiveDropped
+	// 不计入统计, eg: 请求被取消、业务错误
+	AdaptiveIgnore
+)
+
+type AdaptiveLimiterConfig struct {
+	initialLimit int64
+	minLimit     int64
+	maxLimit     int64
+	// 排队数低于 alpha*log10(limit) 时增大并发数，高于 beta*log10(limit) 时减小并发数
+	alpha float64
+	beta  float64
+	// 新并发数的权重，取值 (0, 1]，1 表示不平滑
+	smoothing float64
+	// 每隔这些样本重新估计无负载 rtt
+	probeSamples int64
+}
+
+func NewAdaptiveLimiterConfig() *AdaptiveLimiterConfig {
+	return &AdaptiveLimiterConfig{
+		initialLimit: DefaultAdaptiveInitialLimit,
+		minLimit:     DefaultAdaptiveMinLimit,
+		maxLimit:     DefaultAdaptiveMaxLimit,
+		alpha:        DefaultAdaptiveAlpha,
+		beta:         DefaultAdaptiveBeta,
+		smoothing:    1,
+		probeSamples: DefaultAdaptiveProbeSamples,
+	}
+}
+
+func (c *AdaptiveLimiterConfig) SetInitialLimit(initialLimit int64) *AdaptiveLimiterConfig {
+	c.initialLimit = initialLimit
+	return c
+}
+
+func (c *AdaptiveLimiterConfig) SetMinLimit(minLimit int64) *AdaptiveLimiterConfig {
+	c.minLimit = minLimit
+	return c
+}
+
+func (c *AdaptiveLimiterConfig) SetMaxLimit(maxLimit int64) *AdaptiveLimiterConfig {
+	c.maxLimit = maxLimit
+	return c
+}
+
+func (c *AdaptiveLimiterConfig) SetAlpha(alpha float64) *AdaptiveLimiterConfig {
+	c.alpha = alpha
+	return c
+}
+
+func (c *AdaptiveLimiterConfig) SetBeta(beta float64) *AdaptiveLimiterConfig {
+	c.beta = beta
+	return c
+}
+
+func (c *AdaptiveLimiterConfig) SetSmoothing(smoothing float64) *AdaptiveLimiterConfig {
+	c.smoothing = smoothing
+	return c
+}
+
+func (c *AdaptiveLimiterConfig) SetProbeSamples(probeSamples int64) *AdaptiveLimiterConfig {
+	c.probeSamples = probeSamples
+	return c
+}
+
+/*
+基于 TCP Vegas 的自适应并发限流器。
+
+以观测到的最小延迟作为无负载 rtt，按 limit * (1 - rtt_noload / rtt) 估计排队的请求数：
+排队少时增大并发数，排队多或请求被丢弃时减小并发数。
+无需配置 qps，延迟变大时自动降低并发数，保护下游。
+*/
+type AdaptiveLimiter struct {
+	mu       sync.Mutex // guard all the fields except config
+	config   AdaptiveLimiterConfig
+	limit    float64
+	inflight int64
+	// 无负载 rtt, 0 表示尚未估计
+	minRttNs int64
+	samples  int64
+}
+
+func NewAdaptiveLimiter(config *AdaptiveLimiterConfig) *AdaptiveLimiter {
+	c := *config
+	if c.minLimit < 1 {
+		c.minLimit = 1
+	}
+	if c.maxLimit < c.minLimit {
+		c.maxLimit = c.minLimit
+	}
+	if c.smoothing <= 0 || c.smoothing > 1 {
+		c.smoothing = 1
+	}
+	if c.probeSamples <= 0 {
+		c.probeSamples = DefaultAdaptiveProbeSamples
+	}
+
+	l := &AdaptiveLimiter{
+		config: c,
+		limit:  float64(c.initialLimit),
+	}
+	l.limit = l.clamp(l.limit)
+	return l
+}
+
+// AdaptiveToken 为一个放行的请求，须调用 Release 一次。
+type AdaptiveToken struct {
+	l        *AdaptiveLimiter
+	startNs  int64
+	inflight int64 // 放行时的并发数
+	once     sync.Once
+}
+
+// Acquire 并发数未达上限时放行，否则立即返回 ErrRateLimited。
+func (l *AdaptiveLimiter) Acquire() (*AdaptiveToken, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight >= int64(l.limit) {
+		return nil, ErrRateLimited
+	}
+
+	l.inflight++
+	return &AdaptiveToken{
+		l:        l,
+		startNs:  time.Now().UnixNano(),
+		inflight: l.inflight,
+	}, nil
+}
+
+// Latency 返回放行以来的时间
+func (t *AdaptiveToken) Latency() time.Duration {
+	return time.Duration(time.Now().UnixNano() - t.startNs)
+}
+
+// Release 以请求的结果与延迟调整并发数，重复调用无效。
+func (t *AdaptiveToken) Release(outcome AdaptiveOutcome, latency time.Duration) {
+	t.once.Do(func() {
+		t.l.release(t.inflight, outcome, latency)
+	})
+}
+
+func (l *AdaptiveLimiter) release(inflight int64, outcome AdaptiveOutcome, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+
+	switch outcome {
+	case AdaptiveIgnore:
+		return
+	case AdaptiveDropped:
+		l.setLimit(l.limit - math.Max(1, math.Log10(l.limit)))
+		return
+	}
+
+	rttNs := int64(latency)
+	if rttNs <= 0 {
+		return
+	}
+
+	l.samples++
+	if l.samples >= l.config.probeSamples {
+		// 重新估计，以适应下游的变化
+		l.samples = 0
+		l.minRttNs = 0
+	}
+	if l.minRttNs == 0 || rttNs < l.minRttNs {
+		l.minRttNs = rttNs
+	}
+
+	// 并发数远未达到上限时，延迟不能说明 limit 是否合适
+	if inflight*2 < int64(l.limit) {
+		return
+	}
+
+	queue := math.Ceil(l.limit * (1 - float64(l.minRttNs)/float64(rttNs)))
+	log := math.Max(1, math.Log10(l.limit))
+	switch {
+	case queue <= log:
+		l.setLimit(l.limit + l.config.beta*log)
+	case queue < l.config.alpha*log:
+		l.setLimit(l.limit + log)
+	case queue > l.config.beta*log:
+		l.setLimit(l.limit - log)
+	}
+}
+
+// guarded by mu
+func (l *AdaptiveLimiter) setLimit(newLimit float64) {
+	s := l.config.smoothing
+	l.limit = l.clamp((1-s)*l.limit + s*newLimit)
+}
+
+func (l *AdaptiveLimiter) clamp(limit float64) float64 {
+	return math.Min(float64(l.config.maxLimit), math.Max(float64(l.config.minLimit), limit))
+}
+
+// GetLimit 返回当前允许的并发数
+func (l *AdaptiveLimiter) GetLimit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.limit)
+}
+
+// GetInflight 返回当前的并发数
+func (l *AdaptiveLimiter) GetInflight() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// acquire n tokens and release them with the latency.
+func sendAdaptiveRequests(t *testing.T, l *AdaptiveLimiter, n int64, outcome AdaptiveOutcome, latency time.Duration) {
+	tokens := []*AdaptiveToken{}
+	for i := int64(0); i < n; i++ {
+		token, err := l.Acquire()
+		assert.Nil(t, err)
+		tokens = append(tokens, token)
+	}
+
+	for _, token := range tokens {
+		token.Release(outcome, latency)
+	}
+}
+
+func TestAdaptiveLimiter_Acquire(t *testing.T) {
+	l := NewAdaptiveLimiter(NewAdaptiveLimiterConfig().SetInitialLimit(2))
+
+	a, err := l.Acquire()
+	assert.Nil(t, err)
+	_, err = l.Acquire()
+	assert.Nil(t, err)
+	_, err = l.Acquire()
+	assert.Equal(t, err, ErrRateLimited)
+	assert.Equal(t, l.GetInflight(), int64(2))
+
+	// released only once.
+	a.Release(AdaptiveIgnore, 0)
+	a.Release(AdaptiveIgnore, 0)
+	assert.Equal(t, l.GetInflight(), int64(1))
+	_, err = l.Acquire()
+	assert.Nil(t, err)
+}
+
+func TestAdaptiveLimiter_Vegas(t *testing.T) {
+	l := NewAdaptiveLimiter(NewAdaptiveLimiterConfig().SetInitialLimit(20).SetMaxLimit(100).SetProbeSamples(100000))
+
+	// no queueing, the limit grows.
+	for i := 0; i < 10; i++ {
+		sendAdaptiveRequests(t, l, l.GetLimit(), AdaptiveSuccess, 10*time.Millisecond)
+	}
+	assert.Equal(t, l.GetLimit(), int64(100))
+
+	// the limit stays while the requests are far less than it.
+	sendAdaptiveRequests(t, l, 1, AdaptiveSuccess, 100*time.Millisecond)
+	assert.Equal(t, l.GetLimit(), int64(100))
+
+	// the latency doubles, half of the requests are queueing, the limit shrinks.
+	for i := 0; i < 10; i++ {
+		sendAdaptiveRequests(t, l, l.GetLimit(), AdaptiveSuccess, 20*time.Millisecond)
+	}
+	limit := l.GetLimit()
+	assert.True(t, limit < 100, limit)
+
+	// the drops shrink the limit.
+	sendAdaptiveRequests(t, l, 10, AdaptiveDropped, 0)
+	assert.True(t, l.GetLimit() < limit)
+
+	// never below the min limit.
+	for i := 0; i < 100; i++ {
+		sendAdaptiveRequests(t, l, 1, AdaptiveDropped, 0)
+	}
+	assert.Equal(t, l.GetLimit(), DefaultAdaptiveMinLimit)
+}
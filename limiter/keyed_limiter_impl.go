@@ -0,0 +1,312 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultKeyedMaxKeys    = 10000
+	DefaultKeyedIdleTtlMs  = int64(600000)
+	keyedEvictCheckPerCall = 2
+)
+
+// KeyLimit 为一个 key 的限流参数
+type KeyLimit struct {
+	QpsThreshold int64
+	// 小于 1 时与 QpsThreshold 相同
+	Burst int64
+}
+
+func (k KeyLimit) getBurst() int64 {
+	if k.Burst < 1 {
+		return k.QpsThreshold
+	}
+	return k.Burst
+}
+
+type KeyedRateLimiterConfig struct {
+	qpsThreshold int64
+	burst        int64
+	// 最多保存的 key 数，超过时淘汰最久未访问的 key
+	maxKeys int
+	// 超过 idleTtlMs 未访问的 key 被淘汰，0 表示不按时间淘汰
+	idleTtlMs int64
+	// key => 单独的限流参数, eg: vip 用户
+	overrides map[string]KeyLimit
+}
+
+func NewKeyedRateLimiterConfig() *KeyedRateLimiterConfig {
+	return &KeyedRateLimiterConfig{
+		maxKeys:   DefaultKeyedMaxKeys,
+		idleTtlMs: DefaultKeyedIdleTtlMs,
+		overrides: make(map[string]KeyLimit),
+	}
+}
+
+func (c *KeyedRateLimiterConfig) SetQpsThreshold(qpsThreshold int64) *KeyedRateLimiterConfig {
+	c.qpsThreshold = qpsThreshold
+	return c
+}
+
+// burst 小于 1 时与 qpsThreshold 相同
+func (c *KeyedRateLimiterConfig) SetBurst(burst int64) *KeyedRateLimiterConfig {
+	c.burst = burst
+	return c
+}
+
+func (c *KeyedRateLimiterConfig) SetMaxKeys(maxKeys int) *KeyedRateLimiterConfig {
+	c.maxKeys = maxKeys
+	return c
+}
+
+func (c *KeyedRateLimiterConfig) SetIdleTtlMs(idleTtlMs int64) *KeyedRateLimiterConfig {
+	c.idleTtlMs = idleTtlMs
+	return c
+}
+
+func (c *KeyedRateLimiterConfig) SetOverride(key string, limit KeyLimit) *KeyedRateLimiterConfig {
+	c.overrides[key] = limit
+	return c
+}
+
+// LRU 中的一个 key
+type keyedEntry struct {
+	key      string
+	limiter  *TokenBucketRateLimiter
+	lastNs   int64 // 最近一次访问的时间
+	override bool
+}
+
+/*
+按 key(eg: 用户、ip、api key)限流的限流器族。
+
+每个 key 在第一次访问时创建一个 TokenBucketRateLimiter，只占少量内存，没有后台 goroutine。
+key 按 LRU 组织，超过 maxKeys 或超过 idleTtlMs 未访问时在访问中被淘汰，
+被淘汰的 key 再次访问时以满桶重新创建。
+*/
+type KeyedRateLimiter struct {
+	mu        sync.Mutex // guard all the fields
+	limit     KeyLimit
+	maxKeys   int
+	idleTtlNs int64
+	overrides map[string]KeyLimit
+	// 队首为最近访问的 key
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// maxKeys 小于 1 时取 DefaultKeyedMaxKeys
+func NewKeyedRateLimiter(conf *KeyedRateLimiterConfig) *KeyedRateLimiter {
+	maxKeys := conf.maxKeys
+	if maxKeys < 1 {
+		maxKeys = DefaultKeyedMaxKeys
+	}
+
+	overrides := make(map[string]KeyLimit, len(conf.overrides))
+	for key, limit := range conf.overrides {
+		overrides[key] = limit
+	}
+
+	return &KeyedRateLimiter{
+		limit:     KeyLimit{QpsThreshold: conf.qpsThreshold, Burst: conf.burst},
+		maxKeys:   maxKeys,
+		idleTtlNs: conf.idleTtlMs * int64(time.Millisecond),
+		overrides: overrides,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+// 返回 key 的限流器，不存在时创建, guarded by mu
+func (krl *KeyedRateLimiter) get(nowNs int64, key string) *TokenBucketRateLimiter {
+	if elem, ok := krl.entries[key]; ok {
+		entry := elem.Value.(*keyedEntry)
+		if krl.idleTtlNs <= 0 || nowNs-entry.lastNs <= krl.idleTtlNs {
+			entry.lastNs = nowNs
+			krl.lru.MoveToFront(elem)
+			krl.evict(nowNs)
+			return entry.limiter
+		}
+		// 已过期，按新 key 重新创建
+		krl.remove(elem)
+	}
+
+	limit, override := krl.overrides[key]
+	if !override {
+		limit = krl.limit
+	}
+
+	entry := &keyedEntry{
+		key:      key,
+		limiter:  NewTokenBucketRateLimiter(limit.QpsThreshold, limit.getBurst()),
+		lastNs:   nowNs,
+		override: override,
+	}
+	entry.limiter.lastNs = nowNs
+	krl.entries[key] = krl.lru.PushFront(entry)
+	krl.evict(nowNs)
+	return entry.limiter
+}
+
+/*
+淘汰超出 maxKeys 的 key，以及队尾过期的 key, guarded by mu。
+每次最多淘汰 keyedEvictCheckPerCall 个过期的 key，分摊淘汰的开销。
+*/
+func (krl *KeyedRateLimiter) evict(nowNs int64) {
+	for krl.lru.Len() > krl.maxKeys {
+		krl.remove(krl.lru.Back())
+	}
+
+	if krl.idleTtlNs <= 0 {
+		return
+	}
+
+	for i := 0; i < keyedEvictCheckPerCall; i++ {
+		elem := krl.lru.Back()
+		if elem == nil || nowNs-elem.Value.(*keyedEntry).lastNs <= krl.idleTtlNs {
+			return
+		}
+		krl.remove(elem)
+	}
+}
+
+// guarded by mu
+func (krl *KeyedRateLimiter) remove(elem *list.Element) {
+	krl.lru.Remove(elem)
+	delete(krl.entries, elem.Value.(*keyedEntry).key)
+}
+
+func (krl *KeyedRateLimiter) reserve(nowNs int64, key string, n int64, maxWaitNs int64) (int64, bool) {
+	krl.mu.Lock()
+	l := krl.get(nowNs, key)
+	krl.mu.Unlock()
+
+	return l.reserve(nowNs, n, maxWaitNs)
+}
+
+// 如果被限流，则返回 ErrRateLimited；未被限流，则返回 nil
+func (krl *KeyedRateLimiter) Limit() error {
+	return krl.LimitKey(DefaultLimiterKey)
+}
+
+// 对 key 限流，如果被限流，则返回 ErrRateLimited；未被限流，则返回 nil
+func (krl *KeyedRateLimiter) LimitKey(key string) error {
+	if !krl.AllowKey(key) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// AllowKey key 有 token 时消耗一个并返回 true，否则立即返回 false。
+func (krl *KeyedRateLimiter) AllowKey(key string) bool {
+	_, ok := krl.reserve(time.Now().UnixNano(), key, 1, 0)
+	return ok
+}
+
+/*
+WaitKey 阻塞直到 key 获得一个 token。
+
+ctx 结束时返回 ctx.Err()，等待时间超过 ctx 的 deadline 时立即返回 ErrExceedDeadline，
+qpsThreshold 不大于 0 且 token 不足时立即返回 ErrRateLimited。
+*/
+func (krl *KeyedRateLimiter) WaitKey(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	nowNs := time.Now().UnixNano()
+	maxWaitNs := int64(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWaitNs = deadline.UnixNano() - nowNs
+	}
+
+	krl.mu.Lock()
+	l := krl.get(nowNs, key)
+	krl.mu.Unlock()
+
+	waitNs, ok := l.reserve(nowNs, 1, maxWaitNs)
+	if !ok {
+		return waitErr(maxWaitNs)
+	}
+	if waitNs == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(waitNs))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel(time.Now().UnixNano(), 1)
+		return ctx.Err()
+	}
+}
+
+// SetOverride 修改 key 的限流参数，已存在的 key 以满桶重新创建。
+func (krl *KeyedRateLimiter) SetOverride(key string, limit KeyLimit) {
+	krl.mu.Lock()
+	defer krl.mu.Unlock()
+
+	krl.overrides[key] = limit
+	if elem, ok := krl.entries[key]; ok {
+		krl.remove(elem)
+	}
+}
+
+// DeleteOverride 恢复 key 的默认限流参数。
+func (krl *KeyedRateLimiter) DeleteOverride(key string) {
+	krl.mu.Lock()
+	defer krl.mu.Unlock()
+
+	if _, ok := krl.overrides[key]; !ok {
+		return
+	}
+
+	delete(krl.overrides, key)
+	if elem, ok := krl.entries[key]; ok {
+		krl.remove(elem)
+	}
+}
+
+// Len 返回当前保存的 key 数
+func (krl *KeyedRateLimiter) Len() int {
+	krl.mu.Lock()
+	defer krl.mu.Unlock()
+	return krl.lru.Len()
+}
+
+/*
+ChangeQpsThreshold 修改默认的 qpsThreshold，不影响单独配置的 key。
+未配置 burst 时，burst 随 qpsThreshold 修改。
+*/
+func (krl *KeyedRateLimiter) ChangeQpsThreshold(newQpsThreshold int64) {
+	krl.mu.Lock()
+	defer krl.mu.Unlock()
+
+	krl.limit.QpsThreshold = newQpsThreshold
+	for elem := krl.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*keyedEntry)
+		if entry.override {
+			continue
+		}
+
+		entry.limiter.ChangeQpsThreshold(newQpsThreshold)
+		if krl.limit.Burst < 1 {
+			entry.limiter.changeBurst(newQpsThreshold)
+		}
+	}
+}
+
+func (krl *KeyedRateLimiter) Close() {
+	krl.mu.Lock()
+	defer krl.mu.Unlock()
+
+	krl.lru.Init()
+	krl.entries = make(map[string]*list.Element)
+}
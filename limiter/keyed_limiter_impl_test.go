@@ -0,0 +1,89 @@
+package limiter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// allowed actions of the key in the same instant.
+func allowKeyTimes(krl *KeyedRateLimiter, nowNs int64, key string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if _, ok := krl.reserve(nowNs, key, 1, 0); ok {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestKeyedRateLimiter_Override(t *testing.T) {
+	krl := NewKeyedRateLimiter(NewKeyedRateLimiterConfig().
+		SetQpsThreshold(10).
+		SetBurst(5).
+		SetOverride("vip", KeyLimit{QpsThreshold: 100}))
+	nowNs := time.Now().UnixNano()
+
+	// every key has its own bucket.
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "a", 10), 5)
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "b", 10), 5)
+	// the burst of the override is the qps.
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "vip", 200), 100)
+
+	// 100ms refills 1 token of the default keys.
+	assert.Equal(t, allowKeyTimes(krl, nowNs+int64(100*time.Millisecond), "a", 10), 1)
+
+	krl.SetOverride("a", KeyLimit{QpsThreshold: 1, Burst: 1})
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "a", 10), 1)
+	krl.DeleteOverride("a")
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "a", 10), 5)
+
+	// the overrides keep their qps.
+	krl.ChangeQpsThreshold(20)
+	assert.Equal(t, allowKeyTimes(krl, nowNs+int64(time.Second), "b", 100), 5)
+	assert.Equal(t, allowKeyTimes(krl, nowNs+int64(time.Second), "vip", 200), 100)
+}
+
+func TestKeyedRateLimiter_ChangeBurst(t *testing.T) {
+	krl := NewKeyedRateLimiter(NewKeyedRateLimiterConfig().SetQpsThreshold(10))
+	nowNs := time.Now().UnixNano()
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "a", 100), 10)
+
+	// the burst of the existing keys follows the qps without a burst.
+	krl.ChangeQpsThreshold(20)
+	assert.Equal(t, allowKeyTimes(krl, nowNs+int64(2*time.Second), "a", 100), 20)
+	assert.Equal(t, allowKeyTimes(krl, nowNs+int64(2*time.Second), "b", 100), 20)
+
+	krl.ChangeQpsThreshold(5)
+	assert.Equal(t, allowKeyTimes(krl, nowNs+int64(4*time.Second), "a", 100), 5)
+}
+
+func TestKeyedRateLimiter_Evict(t *testing.T) {
+	krl := NewKeyedRateLimiter(NewKeyedRateLimiterConfig().
+		SetQpsThreshold(1).
+		SetMaxKeys(100).
+		SetIdleTtlMs(1000))
+	nowNs := time.Now().UnixNano()
+
+	// lru.
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, allowKeyTimes(krl, nowNs, strconv.Itoa(i), 1), 1)
+	}
+	assert.Equal(t, krl.Len(), 100)
+	// the evicted key is created again with a full bucket.
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "0", 1), 1)
+	assert.Equal(t, allowKeyTimes(krl, nowNs, "999", 1), 0)
+
+	// ttl, the idle keys are evicted while accessing the others.
+	laterNs := nowNs + int64(2*time.Second)
+	for i := 0; i < 100; i++ {
+		allowKeyTimes(krl, laterNs, "active", 1)
+	}
+	assert.Equal(t, krl.Len(), 1)
+
+	krl.Close()
+	assert.Equal(t, krl.Len(), 0)
+	assert.Nil(t, krl.Limit())
+}
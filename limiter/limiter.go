@@ -11,14 +11,23 @@ type Limiter interface {
 const (
 	LeakyBucketTyp   = "leak_bucket"
 	SildingWindowTyp = "silding_window"
+	TokenBucketTyp   = "token_bucket"
 )
 
+// token bucket 的 burst 默认为 qpsThreshold，即最多积攒 1s 的 token。
 func NewLimiter(qpsThreshold int64, limiterTyp string) (Limiter, error) {
+	return NewLimiterWithBurst(qpsThreshold, qpsThreshold, limiterTyp)
+}
+
+// burst 只对 token bucket 有效。
+func NewLimiterWithBurst(qpsThreshold int64, burst int64, limiterTyp string) (Limiter, error) {
 	switch limiterTyp {
 	case LeakyBucketTyp:
 		return NewLeakyBucketRateLimiter(qpsThreshold), nil
 	case SildingWindowTyp:
 		return NewSlidingWindowRateLimiter(qpsThreshold), nil
+	case TokenBucketTyp:
+		return NewTokenBucketRateLimiter(qpsThreshold, burst), nil
 	default:
 		return nil, errors.New("limiter type error")
 	}
@@ -0,0 +1,295 @@
+package limiter
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/EAHITechnology/raptor/eredis"
+	"github.com/EAHITechnology/raptor/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	DefaultRedisLimiterKeyPrefix = "raptor:limiter"
+	// Limit 使用的 key
+	DefaultLimiterKey = "default"
+	// redis 出错后，每隔这些时间探测一次 redis
+	DefaultRedisLimiterRetryIntervalMs = int64(1000)
+)
+
+var (
+	ErrScriptRunnerNil         = errors.New("script runner nil")
+	ErrFallbackQpsThresholdNil = errors.New("fallback qps threshold and instances nil")
+)
+
+/*
+GCRA(generic cell rate algorithm)，以 redis 的时间为准，避免各实例的时钟偏差。
+
+KEYS[1]: key
+ARGV[1]: 每个 token 的间隔(微秒)
+ARGV[2]: burst
+ARGV[3]: 消耗的 token 数
+
+key 中保存 TAT(theoretical arrival time)，返回 {是否放行, 需等待的微秒数}。
+*/
+const gcraLua = `
+redis.replicate_commands()
+local key = KEYS[1]
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", key))
+if not tat or tat < now then
+	tat = now
+end
+
+local new_tat = tat + n * interval
+local allow_at = new_tat - burst * interval
+if allow_at > now then
+	return {0, allow_at - now}
+end
+
+redis.call("SET", key, new_tat, "PX", math.ceil((new_tat - now) / 1000) + 1)
+return {1, 0}
+`
+
+// ScriptRunner 执行 lua 脚本, eg: *eredis.Redis。
+type ScriptRunner interface {
+	Script(keyCount int, data string, args []string) (interface{}, error)
+}
+
+var _ ScriptRunner = (*eredis.Redis)(nil)
+
+type RedisRateLimiterConfig struct {
+	keyPrefix    string
+	qpsThreshold int64
+	burst        int64
+	// redis 出错时每个实例的本地限流器的 qps, 0 表示按 instances 均分 qpsThreshold
+	fallbackQpsThreshold int64
+	// 共享 qpsThreshold 的实例数
+	instances       int64
+	retryIntervalMs int64
+}
+
+func NewRedisRateLimiterConfig() *RedisRateLimiterConfig {
+	return &RedisRateLimiterConfig{
+		keyPrefix:       DefaultRedisLimiterKeyPrefix,
+		retryIntervalMs: DefaultRedisLimiterRetryIntervalMs,
+	}
+}
+
+func (c *RedisRateLimiterConfig) SetKeyPrefix(keyPrefix string) *RedisRateLimiterConfig {
+	c.keyPrefix = keyPrefix
+	return c
+}
+
+func (c *RedisRateLimiterConfig) SetQpsThreshold(qpsThreshold int64) *RedisRateLimiterConfig {
+	c.qpsThreshold = qpsThreshold
+	return c
+}
+
+func (c *RedisRateLimiterConfig) SetBurst(burst int64) *RedisRateLimiterConfig {
+	c.burst = burst
+	return c
+}
+
+func (c *RedisRateLimiterConfig) SetFallbackQpsThreshold(fallbackQpsThreshold int64) *RedisRateLimiterConfig {
+	c.fallbackQpsThreshold = fallbackQpsThreshold
+	return c
+}
+
+// 未设置 fallbackQpsThreshold 时，本地限流器的 qps 与 burst 为 qpsThreshold 与 burst 的 1/instances
+func (c *RedisRateLimiterConfig) SetInstances(instances int64) *RedisRateLimiterConfig {
+	c.instances = instances
+	return c
+}
+
+// 不大于 0 时取 DefaultRedisLimiterRetryIntervalMs
+func (c *RedisRateLimiterConfig) SetRetryIntervalMs(retryIntervalMs int64) *RedisRateLimiterConfig {
+	c.retryIntervalMs = retryIntervalMs
+	return c
+}
+
+/*
+基于 redis 的集群限流器，所有实例共享 qpsThreshold。
+
+每个 key(eg: 租户)单独限流，redis 出错时退化为每个实例、每个 key 一个本地的 token bucket 限流器，
+本地限流器按 KeyedRateLimiter 的默认参数淘汰。
+退化后不再访问 redis，每隔 retryIntervalMs 由一个请求探测 redis，成功后恢复。
+*/
+type RedisRateLimiter struct {
+	client       ScriptRunner
+	keyPrefix    string
+	burst        int64
+	qpsThreshold int64 // read/write through atomic operation
+
+	// 大于 0 时 ChangeQpsThreshold 不修改本地限流器的 qps
+	fallbackQpsThreshold int64
+	instances            int64
+	// 每个 key 一个本地限流器，按 LRU 淘汰
+	fallbacks *KeyedRateLimiter
+	// redis 不可用
+	degraded        int32 // read/write through atomic operation
+	retryIntervalNs int64
+	// 退化后下次探测 redis 的时间
+	retryAtNs int64 // read/write through atomic operation
+}
+
+/*
+burst 小于 1 时取 1。
+fallbackQpsThreshold 与 instances 都未设置时返回 ErrFallbackQpsThresholdNil，
+避免 redis 出错时每个实例都放行 qpsThreshold。
+*/
+func NewRedisRateLimiter(client ScriptRunner, conf *RedisRateLimiterConfig) (*RedisRateLimiter, error) {
+	if client == nil || utils.IsNil(client) {
+		return nil, ErrScriptRunnerNil
+	}
+
+	if conf.fallbackQpsThreshold <= 0 && conf.instances <= 0 {
+		return nil, ErrFallbackQpsThresholdNil
+	}
+
+	burst := conf.burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	keyPrefix := conf.keyPrefix
+	if keyPrefix == "" {
+		keyPrefix = DefaultRedisLimiterKeyPrefix
+	}
+
+	retryIntervalMs := conf.retryIntervalMs
+	if retryIntervalMs <= 0 {
+		retryIntervalMs = DefaultRedisLimiterRetryIntervalMs
+	}
+
+	fallbackQpsThreshold, fallbackBurst := conf.fallbackQpsThreshold, burst
+	if fallbackQpsThreshold <= 0 {
+		fallbackQpsThreshold = share(conf.qpsThreshold, conf.instances)
+		fallbackBurst = share(burst, conf.instances)
+	}
+
+	return &RedisRateLimiter{
+		client:               client,
+		keyPrefix:            keyPrefix,
+		burst:                burst,
+		qpsThreshold:         conf.qpsThreshold,
+		fallbackQpsThreshold: conf.fallbackQpsThreshold,
+		instances:            conf.instances,
+		fallbacks: NewKeyedRateLimiter(NewKeyedRateLimiterConfig().
+			SetQpsThreshold(fallbackQpsThreshold).
+			SetBurst(fallbackBurst)),
+		retryIntervalNs: retryIntervalMs * int64(time.Millisecond),
+	}, nil
+}
+
+// 每个实例的份额，qpsThreshold 大于 0 时至少为 1
+func share(total int64, instances int64) int64 {
+	if total <= 0 {
+		return total
+	}
+	return int64(math.Max(1, math.Floor(float64(total)/float64(instances))))
+}
+
+// 如果被限流，则返回 ErrRateLimited；未被限流，则返回 nil
+func (rrl *RedisRateLimiter) Limit() error {
+	return rrl.LimitKey(DefaultLimiterKey)
+}
+
+// 对 key 限流，如果被限流，则返回 ErrRateLimited；未被限流，则返回 nil
+func (rrl *RedisRateLimiter) LimitKey(key string) error {
+	if !rrl.AllowKey(key) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// AllowKey 立即返回 key 是否放行。
+func (rrl *RedisRateLimiter) AllowKey(key string) bool {
+	allowed, _ := rrl.AllowKeyN(key, 1)
+	return allowed
+}
+
+/*
+AllowKeyN 立即返回 key 是否放行 n 个动作，放行时消耗 n 个 token。
+不放行时不消耗 token，并返回需等待的时间，退化为本地限流器时等待时间为 0。
+n 小于 1 时不放行。
+*/
+func (rrl *RedisRateLimiter) AllowKeyN(key string, n int64) (bool, time.Duration) {
+	if n < 1 {
+		return false, 0
+	}
+
+	nowNs := time.Now().UnixNano()
+	if rrl.Degraded() && !rrl.acquireProbe(nowNs) {
+		_, allowed := rrl.fallbacks.reserve(nowNs, key, n, 0)
+		return allowed, 0
+	}
+
+	allowed, retryAfter, err := rrl.reserve(key, n)
+	if err != nil {
+		atomic.StoreInt64(&rrl.retryAtNs, nowNs+rrl.retryIntervalNs)
+		atomic.StoreInt32(&rrl.degraded, 1)
+		_, allowed := rrl.fallbacks.reserve(nowNs, key, n, 0)
+		return allowed, 0
+	}
+
+	atomic.StoreInt32(&rrl.degraded, 0)
+	return allowed, retryAfter
+}
+
+// 退化后每隔 retryIntervalNs 只有一个请求探测 redis
+func (rrl *RedisRateLimiter) acquireProbe(nowNs int64) bool {
+	retryAtNs := atomic.LoadInt64(&rrl.retryAtNs)
+	if nowNs < retryAtNs {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&rrl.retryAtNs, retryAtNs, nowNs+rrl.retryIntervalNs)
+}
+
+func (rrl *RedisRateLimiter) reserve(key string, n int64) (bool, time.Duration, error) {
+	qpsThreshold := atomic.LoadInt64(&rrl.qpsThreshold)
+	if qpsThreshold <= 0 {
+		return false, 0, nil
+	}
+
+	intervalUs := int64(math.Ceil(float64(time.Second/time.Microsecond) / float64(qpsThreshold)))
+	reply, err := redis.Int64s(rrl.client.Script(1, gcraLua, []string{
+		rrl.keyPrefix + ":" + key,
+		strconv.FormatInt(intervalUs, 10),
+		strconv.FormatInt(rrl.burst, 10),
+		strconv.FormatInt(n, 10),
+	}))
+	if err != nil {
+		return false, 0, err
+	}
+
+	if len(reply) != 2 {
+		return false, 0, errors.New("gcra reply illegal")
+	}
+	return reply[0] == 1, time.Duration(reply[1]) * time.Microsecond, nil
+}
+
+// Degraded 表示最近一次访问 redis 出错，正在使用本地限流器，直到探测 redis 成功。
+func (rrl *RedisRateLimiter) Degraded() bool {
+	return atomic.LoadInt32(&rrl.degraded) == 1
+}
+
+func (rrl *RedisRateLimiter) ChangeQpsThreshold(newQpsThreshold int64) {
+	atomic.StoreInt64(&rrl.qpsThreshold, newQpsThreshold)
+	if rrl.fallbackQpsThreshold <= 0 {
+		rrl.fallbacks.ChangeQpsThreshold(share(newQpsThreshold, rrl.instances))
+	}
+}
+
+func (rrl *RedisRateLimiter) Close() {
+	rrl.fallbacks.Close()
+}
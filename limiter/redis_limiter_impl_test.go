@@ -0,0 +1,194 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EAHITechnology/raptor/eredis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// in-process stand-in of redis running gcraLua, with a fake clock in microseconds.
+type fakeScriptRunner struct {
+	lock  sync.Mutex
+	nowUs int64
+	tats  map[string]int64
+	// the redis is unreachable.
+	down  bool
+	calls int
+}
+
+func newFakeScriptRunner() *fakeScriptRunner {
+	return &fakeScriptRunner{
+		nowUs: time.Now().UnixNano() / 1000,
+		tats:  make(map[string]int64),
+	}
+}
+
+func (f *fakeScriptRunner) Script(keyCount int, data string, args []string) (interface{}, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls++
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	if keyCount != 1 || data != gcraLua || len(args) != 4 {
+		return nil, errors.New("unknown script")
+	}
+
+	interval, _ := strconv.ParseInt(args[1], 10, 64)
+	burst, _ := strconv.ParseInt(args[2], 10, 64)
+	n, _ := strconv.ParseInt(args[3], 10, 64)
+
+	tat, ok := f.tats[args[0]]
+	if !ok || tat < f.nowUs {
+		tat = f.nowUs
+	}
+
+	newTat := tat + n*interval
+	allowAt := newTat - burst*interval
+	if allowAt > f.nowUs {
+		return []interface{}{int64(0), allowAt - f.nowUs}, nil
+	}
+
+	f.tats[args[0]] = newTat
+	return []interface{}{int64(1), int64(0)}, nil
+}
+
+func (f *fakeScriptRunner) sleep(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nowUs += int64(d / time.Microsecond)
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	client := newFakeScriptRunner()
+	conf := NewRedisRateLimiterConfig().SetQpsThreshold(10).SetBurst(5).SetInstances(2)
+
+	// two instances share the rate.
+	a, err := NewRedisRateLimiter(client, conf)
+	assert.Nil(t, err)
+	b, err := NewRedisRateLimiter(client, conf)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, a.Limit())
+	}
+	assert.Equal(t, b.Limit(), ErrRateLimited)
+
+	allowed, retryAfter := b.AllowKeyN(DefaultLimiterKey, 1)
+	assert.False(t, allowed)
+	assert.Equal(t, retryAfter, 100*time.Millisecond)
+
+	client.sleep(100 * time.Millisecond)
+	assert.Nil(t, b.Limit())
+	assert.Equal(t, a.Limit(), ErrRateLimited)
+
+	// every key has its own rate.
+	for i := 0; i < 5; i++ {
+		assert.True(t, a.AllowKey("tenant-1"))
+	}
+	assert.False(t, b.AllowKey("tenant-1"))
+	assert.True(t, b.AllowKey("tenant-2"))
+
+	allowed, _ = a.AllowKeyN("tenant-3", 6)
+	assert.False(t, allowed)
+
+	_, err = NewRedisRateLimiter(nil, conf)
+	assert.Equal(t, err, ErrScriptRunnerNil)
+
+	// every instance would allow the whole qpsThreshold while the redis is down.
+	_, err = NewRedisRateLimiter(client, NewRedisRateLimiterConfig().SetQpsThreshold(10))
+	assert.Equal(t, err, ErrFallbackQpsThresholdNil)
+}
+
+func TestRedisRateLimiter_Fallback(t *testing.T) {
+	client := newFakeScriptRunner()
+	client.down = true
+	a, err := NewRedisRateLimiter(client, NewRedisRateLimiterConfig().
+		SetQpsThreshold(100).
+		SetBurst(3).
+		SetFallbackQpsThreshold(1).
+		SetRetryIntervalMs(50))
+	assert.Nil(t, err)
+
+	// the local limiter of every key takes over.
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, a.LimitKey("tenant-1"))
+	}
+	assert.Equal(t, a.LimitKey("tenant-1"), ErrRateLimited)
+	assert.Nil(t, a.LimitKey("tenant-2"))
+	assert.True(t, a.Degraded())
+
+	// the redis is not called until the retry interval passes.
+	assert.Equal(t, client.calls, 1)
+	client.down = false
+	assert.Equal(t, a.LimitKey("tenant-1"), ErrRateLimited)
+	assert.True(t, a.Degraded())
+	assert.Equal(t, client.calls, 1)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, a.LimitKey("tenant-1"))
+	assert.False(t, a.Degraded())
+	assert.Equal(t, client.calls, 2)
+
+	allowed, _ := a.AllowKeyN("tenant-1", 0)
+	assert.False(t, allowed)
+	allowed, _ = a.AllowKeyN("tenant-1", -1)
+	assert.False(t, allowed)
+
+	// the local limiters share the qpsThreshold with the other instances without a fallbackQpsThreshold.
+	client.down = true
+	b, err := NewRedisRateLimiter(client, NewRedisRateLimiterConfig().SetQpsThreshold(4).SetBurst(4).SetInstances(4))
+	assert.Nil(t, err)
+	assert.Nil(t, b.LimitKey("tenant-1"))
+	assert.Equal(t, b.LimitKey("tenant-1"), ErrRateLimited)
+	b.ChangeQpsThreshold(400)
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, b.LimitKey("tenant-1"))
+	assert.Equal(t, b.fallbacks.Len(), 1)
+}
+
+// gcraLua runs on an in-process redis.
+func TestRedisRateLimiter_Gcra(t *testing.T) {
+	m, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer m.Close()
+
+	now := time.Now()
+	m.SetTime(now)
+
+	client, err := eredis.NewRedis(context.Background(), eredis.RedisInfo{RedisName: "limiter", Addr: m.Addr()})
+	assert.Nil(t, err)
+	defer client.Close()
+
+	l, err := NewRedisRateLimiter(client, NewRedisRateLimiterConfig().SetQpsThreshold(10).SetBurst(3).SetInstances(1))
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := l.AllowKeyN("tenant-1", 1)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter := l.AllowKeyN("tenant-1", 1)
+	assert.False(t, allowed)
+	assert.Equal(t, retryAfter, 100*time.Millisecond)
+	assert.False(t, l.Degraded())
+
+	// the other keys are limited separately.
+	allowed, _ = l.AllowKeyN("tenant-2", 3)
+	assert.True(t, allowed)
+
+	// one token every 100ms.
+	m.SetTime(now.Add(100 * time.Millisecond))
+	allowed, _ = l.AllowKeyN("tenant-1", 1)
+	assert.True(t, allowed)
+	allowed, _ = l.AllowKeyN("tenant-1", 1)
+	assert.False(t, allowed)
+	assert.True(t, m.Exists(DefaultRedisLimiterKeyPrefix+":tenant-1"))
+}
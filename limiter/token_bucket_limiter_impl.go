@@ -0,0 +1,200 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	ErrExceedBurst    error = errors.New("tokens exceed the burst")
+	ErrExceedDeadline error = errors.New("rate limit wait exceeds the context deadline")
+	ErrTokensIllegal  error = errors.New("tokens less than 1")
+)
+
+/*
+基于 token bucket 的，并发安全的限流器。
+
+桶内以 qpsThreshold 每秒的速度生成 token，最多存放 burst 个，每次动作消耗 token。
+桶满时允许 burst 个动作同时通过，之后按 qpsThreshold 的速度放行。
+token 在调用时按流逝的时间计算，没有后台 goroutine。
+*/
+type TokenBucketRateLimiter struct {
+	mu           sync.Mutex // guard qpsThreshold, burst, tokens, lastNs
+	qpsThreshold int64
+	burst        int64
+	tokens       float64 // 可能为负，表示已被预留的 token
+	lastNs       int64   // 上次计算 tokens 的时间
+}
+
+// burst 小于 1 时取 1
+func NewTokenBucketRateLimiter(qpsThreshold int64, burst int64) *TokenBucketRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucketRateLimiter{
+		qpsThreshold: qpsThreshold,
+		burst:        burst,
+		tokens:       float64(burst),
+		lastNs:       time.Now().UnixNano(),
+	}
+}
+
+// 计算到 nowNs 为止生成的 token, guarded by mu
+func (tbrl *TokenBucketRateLimiter) advance(nowNs int64) {
+	if nowNs <= tbrl.lastNs {
+		return
+	}
+
+	elapsed := float64(nowNs-tbrl.lastNs) / float64(time.Second)
+	tbrl.tokens = math.Min(float64(tbrl.burst), tbrl.tokens+elapsed*float64(tbrl.qpsThreshold))
+	tbrl.lastNs = nowNs
+}
+
+/*
+预留 n 个 token，返回需等待的时间。
+等待时间超过 maxWaitNs 时不预留，返回 false。
+*/
+func (tbrl *TokenBucketRateLimiter) reserve(nowNs int64, n int64, maxWaitNs int64) (int64, bool) {
+	tbrl.mu.Lock()
+	defer tbrl.mu.Unlock()
+
+	tbrl.advance(nowNs)
+
+	tokens := tbrl.tokens - float64(n)
+	waitNs := int64(0)
+	if tokens < 0 {
+		if tbrl.qpsThreshold <= 0 {
+			return 0, false
+		}
+		waitNs = int64(math.Ceil(-tokens / float64(tbrl.qpsThreshold) * float64(time.Second)))
+	}
+
+	if waitNs > maxWaitNs {
+		return 0, false
+	}
+
+	tbrl.tokens = tokens
+	return waitNs, true
+}
+
+// 归还未使用的 token
+func (tbrl *TokenBucketRateLimiter) cancel(nowNs int64, n int64) {
+	tbrl.mu.Lock()
+	defer tbrl.mu.Unlock()
+
+	tbrl.advance(nowNs)
+	tbrl.tokens = math.Min(float64(tbrl.burst), tbrl.tokens+float64(n))
+}
+
+// Allow 有 token 时消耗一个并返回 true，否则立即返回 false。
+func (tbrl *TokenBucketRateLimiter) Allow() bool {
+	_, ok := tbrl.reserve(time.Now().UnixNano(), 1, 0)
+	return ok
+}
+
+// 如果被限流，则返回 ErrRateLimited；未被限流，则返回 nil
+func (tbrl *TokenBucketRateLimiter) Limit() error {
+	if !tbrl.Allow() {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// reserve 失败的原因，没有 deadline 时只可能是 qpsThreshold 不大于 0
+func waitErr(maxWaitNs int64) error {
+	if maxWaitNs == math.MaxInt64 {
+		return ErrRateLimited
+	}
+	return ErrExceedDeadline
+}
+
+/*
+Wait 阻塞直到获得一个 token。
+
+ctx 结束时返回 ctx.Err()，等待时间超过 ctx 的 deadline 时立即返回 ErrExceedDeadline，
+qpsThreshold 不大于 0 且 token 不足时立即返回 ErrRateLimited，这些情况都不消耗 token。
+*/
+func (tbrl *TokenBucketRateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	nowNs := time.Now().UnixNano()
+	maxWaitNs := int64(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWaitNs = deadline.UnixNano() - nowNs
+	}
+
+	waitNs, ok := tbrl.reserve(nowNs, 1, maxWaitNs)
+	if !ok {
+		return waitErr(maxWaitNs)
+	}
+	if waitNs == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(waitNs))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		tbrl.cancel(time.Now().UnixNano(), 1)
+		return ctx.Err()
+	}
+}
+
+/*
+Reserve 预留 n 个 token，返回动作前需等待的时间，调用方须等待后再执行动作。
+n 小于 1 时返回 ErrTokensIllegal，n 超过 burst 时返回 ErrExceedBurst，
+qpsThreshold 不大于 0 且 token 不足时返回 ErrRateLimited。
+*/
+func (tbrl *TokenBucketRateLimiter) Reserve(n int64) (time.Duration, error) {
+	if n < 1 {
+		return 0, ErrTokensIllegal
+	}
+	tbrl.mu.Lock()
+	burst := tbrl.burst
+	tbrl.mu.Unlock()
+	if n > burst {
+		return 0, ErrExceedBurst
+	}
+
+	waitNs, ok := tbrl.reserve(time.Now().UnixNano(), n, math.MaxInt64)
+	if !ok {
+		return 0, ErrRateLimited
+	}
+	return time.Duration(waitNs), nil
+}
+
+func (tbrl *TokenBucketRateLimiter) ChangeQpsThreshold(newQpsThreshold int64) {
+	tbrl.mu.Lock()
+	defer tbrl.mu.Unlock()
+
+	// 按原速度计算到现在为止生成的 token
+	tbrl.advance(time.Now().UnixNano())
+	tbrl.qpsThreshold = newQpsThreshold
+}
+
+// 修改 burst，多出的 token 被丢弃，burst 小于 1 时取 1
+func (tbrl *TokenBucketRateLimiter) changeBurst(newBurst int64) {
+	if newBurst < 1 {
+		newBurst = 1
+	}
+
+	tbrl.mu.Lock()
+	defer tbrl.mu.Unlock()
+
+	tbrl.advance(time.Now().UnixNano())
+	tbrl.burst = newBurst
+	tbrl.tokens = math.Min(float64(newBurst), tbrl.tokens)
+}
+
+func (tbrl *TokenBucketRateLimiter) Close() {
+
+}
@@ -0,0 +1,97 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketRateLimiter_Burst(t *testing.T) {
+	rateLimiter := NewTokenBucketRateLimiter(10, 5)
+	nowNs := rateLimiter.lastNs
+
+	// a full bucket lets the burst through.
+	for i := 0; i < 5; i++ {
+		_, ok := rateLimiter.reserve(nowNs, 1, 0)
+		assert.True(t, ok)
+	}
+	_, ok := rateLimiter.reserve(nowNs, 1, 0)
+	assert.False(t, ok)
+
+	// one token every 100ms.
+	_, ok = rateLimiter.reserve(nowNs+int64(100*time.Millisecond), 1, 0)
+	assert.True(t, ok)
+
+	// at most burst tokens are saved.
+	for i := 0; i < 5; i++ {
+		_, ok := rateLimiter.reserve(nowNs+int64(10*time.Second), 1, 0)
+		assert.True(t, ok)
+	}
+	_, ok = rateLimiter.reserve(nowNs+int64(10*time.Second), 1, 0)
+	assert.False(t, ok)
+}
+
+func TestTokenBucketRateLimiter_Reserve(t *testing.T) {
+	rateLimiter := NewTokenBucketRateLimiter(10, 5)
+
+	delay, err := rateLimiter.Reserve(5)
+	assert.Nil(t, err)
+	assert.Equal(t, delay, time.Duration(0))
+
+	// the tokens in the future are reserved.
+	delay, err = rateLimiter.Reserve(3)
+	assert.Nil(t, err)
+	assert.InDelta(t, float64(delay), float64(300*time.Millisecond), float64(10*time.Millisecond))
+
+	_, err = rateLimiter.Reserve(6)
+	assert.Equal(t, err, ErrExceedBurst)
+
+	_, err = NewTokenBucketRateLimiter(0, 1).Reserve(2)
+	assert.Equal(t, err, ErrExceedBurst)
+	_, err = NewTokenBucketRateLimiter(0, 1).Reserve(1)
+	assert.Nil(t, err)
+
+	_, err = rateLimiter.Reserve(0)
+	assert.Equal(t, err, ErrTokensIllegal)
+	_, err = rateLimiter.Reserve(-1)
+	assert.Equal(t, err, ErrTokensIllegal)
+}
+
+func TestTokenBucketRateLimiter_Wait(t *testing.T) {
+	rateLimiter := NewTokenBucketRateLimiter(20, 1)
+	assert.True(t, rateLimiter.Allow())
+	assert.False(t, rateLimiter.Allow())
+	assert.Equal(t, rateLimiter.Limit(), ErrRateLimited)
+
+	// waits about 50ms for the next token.
+	start := time.Now()
+	assert.Nil(t, rateLimiter.Wait(context.Background()))
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	// gives up at once when the deadline is too close, without taking the token.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.Equal(t, rateLimiter.Wait(ctx), ErrExceedDeadline)
+
+	// the token is given back when the ctx is canceled while waiting.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond)
+		cancel()
+	}()
+	assert.Equal(t, rateLimiter.Wait(ctx), context.Canceled)
+	rateLimiter.mu.Lock()
+	assert.True(t, rateLimiter.tokens > -1)
+	rateLimiter.mu.Unlock()
+
+	// no token is ever generated, there is no deadline to wait for.
+	stopped := NewTokenBucketRateLimiter(0, 1)
+	assert.Nil(t, stopped.Wait(context.Background()))
+	assert.Equal(t, stopped.Wait(context.Background()), ErrRateLimited)
+
+	limiter, err := NewLimiterWithBurst(100, 10, TokenBucketTyp)
+	assert.Nil(t, err)
+	assert.Equal(t, limiter.(*TokenBucketRateLimiter).burst, int64(10))
+}
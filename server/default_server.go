@@ -8,12 +8,14 @@ import (
 	"syscall"
 
 	"github.com/EAHITechnology/raptor/balancer"
+	"github.com/EAHITechnology/raptor/breaker"
 	"github.com/EAHITechnology/raptor/config"
 	"github.com/EAHITechnology/raptor/elog"
 	"github.com/EAHITechnology/raptor/emysql"
 	"github.com/EAHITechnology/raptor/enet"
 	"github.com/EAHITechnology/raptor/eredis"
 	"github.com/EAHITechnology/raptor/erpc"
+	"github.com/EAHITechnology/raptor/limiter"
 	"github.com/EAHITechnology/raptor/utils"
 	"golang.org/x/net/context"
 )
@@ -24,6 +26,8 @@ type DefaultServer struct {
 	serverConfigParser config.ServerConfigParser
 	configParser       config.ConfigParser
 
+	breakerRegistry *breaker.Registry
+
 	afterInitFunc  atferFuncObj
 	beforeInitFunc beforeFuncObj
 }
@@ -214,6 +218,10 @@ func (d *DefaultServer) mixRpcCall() ([]*erpc.RpcNetConfigInfo, error) {
 			Addr:                r.Addr,
 			Wight:               r.Wight,
 			Balancetype:         r.Balancetype,
+			Ranges:              r.Ranges,
+			BoundedLoadFactor:   r.BoundedLoadFactor,
+			SlowStartWindow:     r.SlowStartWindow,
+			SlowStartAggression: r.SlowStartAggression,
 			DialTimeout:         r.DialTimeout,
 			TimeOut:             r.TimeOut,
 			RetryTimes:          r.RetryTimes,
@@ -223,12 +231,123 @@ func (d *DefaultServer) mixRpcCall() ([]*erpc.RpcNetConfigInfo, error) {
 			IdleConnTimeout:     r.IdleConnTimeout,
 			ReadBufferSize:      r.ReadBufferSize,
 			WriteBufferSize:     r.WriteBufferSize,
+
+			SubsetSize:             r.SubsetSize,
+			Zones:                  r.Zones,
+			ZoneSpilloverThreshold: r.ZoneSpilloverThreshold,
+
+			OutlierConsecutiveFailures: r.OutlierConsecutiveFailures,
+			OutlierFailureRate:         r.OutlierFailureRate,
+			OutlierBaseEjectionTime:    r.OutlierBaseEjectionTime,
+			OutlierMaxEjectionTime:     r.OutlierMaxEjectionTime,
+			OutlierMaxEjectionPercent:  r.OutlierMaxEjectionPercent,
+
+			HealthCheckType:     r.HealthCheckType,
+			HealthCheckPath:     r.HealthCheckPath,
+			HealthCheckInterval: r.HealthCheckInterval,
+			HealthCheckTimeout:  r.HealthCheckTimeout,
+			HealthyThreshold:    r.HealthyThreshold,
+			UnhealthyThreshold:  r.UnhealthyThreshold,
+
+			Breaker:                r.Breaker,
+			AdaptiveMaxConcurrency: r.AdaptiveMaxConcurrency,
 		}
 		rpcNetConfigs = append(rpcNetConfigs, rpcNetConfig)
 	}
 	return rpcNetConfigs, nil
 }
 
+// the conns are dialed on demand by HostInfo.GetHost, for the raw tcp protocols.
+func mixConnPool(rpcConfig *erpc.RpcNetConfigInfo) *balancer.ConnPoolConfig {
+	connPoolConfig := balancer.NewConnPoolConfig().
+		SetMaxActive(rpcConfig.MaxConnsPerAddr).
+		SetDialTimeoutMs(int64(rpcConfig.DialTimeout))
+
+	if rpcConfig.MaxIdleConnsPerAddr > 0 {
+		connPoolConfig.SetMaxIdle(rpcConfig.MaxIdleConnsPerAddr)
+	}
+
+	if rpcConfig.IdleConnTimeout > 0 {
+		connPoolConfig.SetIdleTimeoutMs(int64(rpcConfig.IdleConnTimeout) * 1000)
+	}
+	return connPoolConfig
+}
+
+// nil means the outlier detection is disabled.
+func mixOutlierDetection(rpcConfig *erpc.RpcNetConfigInfo) *balancer.OutlierDetectionConfig {
+	if rpcConfig.OutlierConsecutiveFailures <= 0 && rpcConfig.OutlierFailureRate <= 0 {
+		return nil
+	}
+
+	outlierConfig := balancer.NewOutlierDetectionConfig().
+		SetServiceName(rpcConfig.ServiceName).
+		SetConsecutiveFailures(int64(rpcConfig.OutlierConsecutiveFailures)).
+		SetFailureRateThreshold(int64(rpcConfig.OutlierFailureRate))
+
+	if rpcConfig.OutlierBaseEjectionTime > 0 {
+		outlierConfig.SetBaseEjectionMs(int64(rpcConfig.OutlierBaseEjectionTime))
+	}
+
+	if rpcConfig.OutlierMaxEjectionTime > 0 {
+		outlierConfig.SetMaxEjectionMs(int64(rpcConfig.OutlierMaxEjectionTime))
+	}
+
+	if rpcConfig.OutlierMaxEjectionPercent > 0 {
+		outlierConfig.SetMaxEjectionPercent(int64(rpcConfig.OutlierMaxEjectionPercent))
+	}
+	return outlierConfig
+}
+
+// nil means the active health check is disabled.
+func mixHealthCheck(rpcConfig *erpc.RpcNetConfigInfo) *balancer.HealthCheckConfig {
+	if rpcConfig.HealthCheckType == "" {
+		return nil
+	}
+
+	healthCheckConfig := balancer.NewHealthCheckConfig().
+		SetServiceName(rpcConfig.ServiceName).
+		SetTyp(balancer.HealthCheckTyp(rpcConfig.HealthCheckType)).
+		SetScheme(rpcConfig.Proto)
+
+	if rpcConfig.HealthCheckPath != "" {
+		healthCheckConfig.SetPath(rpcConfig.HealthCheckPath)
+	}
+
+	if rpcConfig.HealthCheckInterval > 0 {
+		healthCheckConfig.SetIntervalMs(int64(rpcConfig.HealthCheckInterval))
+	}
+
+	if rpcConfig.HealthCheckTimeout > 0 {
+		healthCheckConfig.SetTimeoutMs(int64(rpcConfig.HealthCheckTimeout))
+	}
+
+	if rpcConfig.HealthyThreshold > 0 {
+		healthCheckConfig.SetHealthyThreshold(int64(rpcConfig.HealthyThreshold))
+	}
+
+	if rpcConfig.UnhealthyThreshold > 0 {
+		healthCheckConfig.SetUnhealthyThreshold(int64(rpcConfig.UnhealthyThreshold))
+	}
+	return healthCheckConfig
+}
+
+// nil means the adaptive concurrency limiter is disabled.
+func mixAdaptiveLimiter(rpcConfig *erpc.RpcNetConfigInfo) *limiter.AdaptiveLimiter {
+	if rpcConfig.AdaptiveMaxConcurrency <= 0 {
+		return nil
+	}
+
+	maxLimit := int64(rpcConfig.AdaptiveMaxConcurrency)
+	initialLimit := limiter.DefaultAdaptiveInitialLimit
+	if initialLimit > maxLimit {
+		initialLimit = maxLimit
+	}
+
+	return limiter.NewAdaptiveLimiter(limiter.NewAdaptiveLimiterConfig().
+		SetInitialLimit(initialLimit).
+		SetMaxLimit(maxLimit))
+}
+
 func (d *DefaultServer) initLogger() error {
 	logConf, err := d.mixLogConfig()
 	if err != nil {
@@ -278,6 +397,7 @@ func (d *DefaultServer) initRpc(ctx context.Context) error {
 	}
 
 	httpManagerConfiges := []erpc.HttpManagerConfig{}
+	tcpManagerConfiges := []erpc.TcpManagerConfig{}
 	for _, rpcConfig := range rpcConfigs {
 		// service_discovery
 		// sdc := service_discovery.ServiceDiscoveryConfig{}
@@ -308,13 +428,79 @@ func (d *DefaultServer) initRpc(ctx context.Context) error {
 		}
 		balancerConfig := balancer.NewBalancerConfig()
 		balancerConfig.SetBalancerTyp(balancetype)
+		balancerConfig.SetServiceName(rpcConfig.ServiceName)
+		balancerConfig.SetBoundedLoadFactor(rpcConfig.BoundedLoadFactor)
+		balancerConfig.SetSlowStart(int64(rpcConfig.SlowStartWindow), rpcConfig.SlowStartAggression)
+		if rpcConfig.Proto == "tcp" {
+			balancerConfig.SetConnPool(mixConnPool(rpcConfig))
+		}
+
+		localZone := d.serverConfigParser.GetServerConfigInfo().Zone
+		zoneAware := localZone != "" && len(rpcConfig.Zones) > 0
 
 		for idx, addr := range rpcConfig.Addr {
+			if zoneAware {
+				if idx >= len(rpcConfig.Zones) {
+					return ErrZoneNil
+				}
+				balancerConfig.SetItem(balancer.NewZoneBalancerItem(addr, rpcConfig.Wight[idx], rpcConfig.Zones[idx]))
+				continue
+			}
+
+			if balancetype == balancer.RangeType {
+				start, end, err := getKeyRange(rpcConfig.Ranges, idx)
+				if err != nil {
+					return err
+				}
+				balancerConfig.SetItem(balancer.NewRangeBalancerItem(addr, rpcConfig.Wight[idx], start, end))
+				continue
+			}
 			balancerConfig.SetItem(balancer.NewBalancerItem(addr, rpcConfig.Wight[idx]))
 		}
 
-		balancer, err := balancer.NewBalancer((*balancerConfig))
-		if err != nil {
+		var lb balancer.Balancer
+		// the zone aware balancer prefers the local hosts that are available.
+		available, setAvailable := []func(addr string) bool{}, func(available ...func(addr string) bool) {}
+		if zoneAware {
+			zoneLb, err := balancer.NewZoneAwareBalancer(*balancerConfig, localZone, int64(rpcConfig.ZoneSpilloverThreshold))
+			if err != nil {
+				return err
+			}
+			setAvailable = zoneLb.SetAvailable
+			lb = zoneLb
+		} else if rpcConfig.SubsetSize > 0 {
+			// the subset of the client is chosen by its hostname, eg: the pod "user-3" of a stateful set.
+			clientId, err := utils.GetHostName()
+			if err != nil {
+				return err
+			}
+			if lb, err = balancer.NewSubsetBalancer(*balancerConfig, clientId, rpcConfig.SubsetSize); err != nil {
+				return err
+			}
+		} else if lb, err = balancer.NewBalancer((*balancerConfig)); err != nil {
+			return err
+		}
+
+		if outlierConfig := mixOutlierDetection(rpcConfig); outlierConfig != nil {
+			outlierLb, err := balancer.NewOutlierDetectionBalancer(lb, outlierConfig)
+			if err != nil {
+				return err
+			}
+			available = append(available, outlierLb.IsAvailable)
+			lb = outlierLb
+		}
+
+		if healthCheckConfig := mixHealthCheck(rpcConfig); healthCheckConfig != nil {
+			healthCheckLb, err := balancer.NewHealthCheckBalancer(ctx, lb, healthCheckConfig)
+			if err != nil {
+				return err
+			}
+			available = append(available, healthCheckLb.IsAvailable)
+			lb = healthCheckLb
+		}
+		setAvailable(available...)
+
+		if lb, err = balancer.NewMetricsBalancer(lb, *balancerConfig); err != nil {
 			return err
 		}
 
@@ -323,21 +509,43 @@ func (d *DefaultServer) initRpc(ctx context.Context) error {
 			httpManagerConfige := erpc.HttpManagerConfig{
 				Httpconf: &erpc.HttpClientConfig{
 					BaseConfig: *rpcConfig,
+					Breakers:   d.breakerRegistry,
+					Limiter:    mixAdaptiveLimiter(rpcConfig),
 				},
-				Balancer: balancer,
+				Balancer: lb,
 			}
 			httpManagerConfiges = append(httpManagerConfiges, httpManagerConfige)
 		}
+
+		if rpcConfig.Proto == "tcp" {
+			tcpManagerConfiges = append(tcpManagerConfiges, erpc.TcpManagerConfig{
+				BaseConfig: *rpcConfig,
+				Balancer:   lb,
+			})
+		}
 	}
 
 	if err := erpc.NewSingleHttpClientManager(httpManagerConfiges); err != nil {
 		return err
 	}
 
+	if err := erpc.NewSingleTcpClientManager(tcpManagerConfiges); err != nil {
+		return err
+	}
+
 	// etc...
 	return nil
 }
 
+func (d *DefaultServer) initBreaker() error {
+	breakerRegistry, err := breaker.NewRegistry(d.configParser, elog.Elog)
+	if err != nil {
+		return err
+	}
+	d.breakerRegistry = breakerRegistry
+	return nil
+}
+
 func (d *DefaultServer) initMq() error {
 	return nil
 }
@@ -393,6 +601,10 @@ func (d *DefaultServer) Run(ctx context.Context, cancel context.CancelFunc) erro
 		return err
 	}
 
+	if err := d.initBreaker(); err != nil {
+		return err
+	}
+
 	if err := d.initRpc(ctx); err != nil {
 		return err
 	}
@@ -416,7 +628,9 @@ func (d *DefaultServer) Run(ctx context.Context, cancel context.CancelFunc) erro
 			elog.Elog.Infof("ignore os signal: %s , start reload config", sig.String())
 			if err := d.configParser.Reload(); err != nil {
 				elog.Elog.Errorf("server reload config error:%v", err)
+				break
 			}
+			d.breakerRegistry.Reload()
 		default:
 			elog.Elog.Warnf("ignore os signal: %s", sig.String())
 		}
@@ -426,3 +640,7 @@ func (d *DefaultServer) Run(ctx context.Context, cancel context.CancelFunc) erro
 func (d *DefaultServer) GetConfig() config.ConfigParser {
 	return d.configParser
 }
+
+func (d *DefaultServer) GetBreakerRegistry() *breaker.Registry {
+	return d.breakerRegistry
+}
@@ -11,4 +11,7 @@ var (
 	ErrRedisNameNil  = errors.New("redis name is nil")
 	ErrMysqlNameNil  = errors.New("mysql name is nil")
 	ErrMysqlIpNil    = errors.New("mysql ip is nil")
+	ErrRangeNil      = errors.New("key range is nil")
+	ErrRangeIllegal  = errors.New("key range is illegal")
+	ErrZoneNil       = errors.New("zone is nil")
 )
@@ -2,8 +2,10 @@ package server
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/EAHITechnology/raptor/balancer"
+	"github.com/EAHITechnology/raptor/breaker"
 	"github.com/EAHITechnology/raptor/config"
 	"golang.org/x/net/context"
 )
@@ -52,6 +54,10 @@ type Server interface {
 	Run(ctx context.Context, cancel context.CancelFunc) error
 
 	GetConfig() config.ConfigParser
+
+	// Circuit breakers of the resources, configured by the circuit_breaker config.
+	// Available after Run initialized the components.
+	GetBreakerRegistry() *breaker.Registry
 }
 
 func NewServer(ctx context.Context, typ ServerTyp, configPath string) (Server, error) {
@@ -72,10 +78,27 @@ func getBalancerTyp(typ string) (balancer.BalancerTyp, error) {
 		balancetype = balancer.P2cType
 	case "consistency_hash":
 		balancetype = balancer.ConsistencyHashType
+	case "maglev":
+		balancetype = balancer.MaglevType
 	case "range":
 		balancetype = balancer.RangeType
+	case "round_robin":
+		balancetype = balancer.RoundRobinType
 	default:
 		return "", errors.New("illegal type")
 	}
 	return balancetype, nil
 }
+
+// the key range is formatted as "start,end".
+func getKeyRange(ranges []string, idx int) (string, string, error) {
+	if idx >= len(ranges) {
+		return "", "", ErrRangeNil
+	}
+
+	keyRange := strings.SplitN(ranges[idx], ",", 2)
+	if len(keyRange) != 2 {
+		return "", "", ErrRangeIllegal
+	}
+	return keyRange[0], keyRange[1], nil
+}